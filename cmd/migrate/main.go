@@ -0,0 +1,33 @@
+// Command migrate rewrites all stored objects in the configured storage encoding.
+// It accepts the same configuration as the storage service.
+package main
+
+import (
+	"context"
+	"github.com/kulycloud/common/logging"
+	"github.com/kulycloud/storage-redis/config"
+	"github.com/kulycloud/storage-redis/database"
+)
+
+var logger = logging.GetForComponent("migrate")
+
+func main() {
+	defer logging.Sync()
+
+	err := config.ParseConfig()
+	if err != nil {
+		logger.Fatalw("Error parsing config", "error", err)
+	}
+
+	dbConnector := database.NewConnector()
+	err = dbConnector.Connect()
+	if err != nil {
+		logger.Fatalw("Could not connect dbConnector", "error", err)
+	}
+
+	count, err := dbConnector.MigrateStorageEncoding(context.Background(), "")
+	if err != nil {
+		logger.Fatalw("Migration failed", "migrated", count, "error", err)
+	}
+	logger.Infow("Migration finished", "migrated", count, "encoding", config.GlobalConfig.StorageEncoding)
+}
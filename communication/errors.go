@@ -0,0 +1,90 @@
+package communication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/kulycloud/storage-redis/database"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+)
+
+// errorCode maps errors returned by the database layer to the matching gRPC status code
+func errorCode(err error) codes.Code {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, database.ErrorNotFound), errors.Is(err, redis.Nil):
+		return codes.NotFound
+	case errors.Is(err, database.ErrInvalidUid), errors.Is(err, database.ErrInvalidRoute), errors.Is(err, ErrInvalidRequest):
+		return codes.InvalidArgument
+	case errors.Is(err, database.ErrHostConflict), errors.Is(err, database.ErrNamespaceExists):
+		return codes.AlreadyExists
+	case errors.Is(err, database.ErrQuotaExceeded):
+		return codes.ResourceExhausted
+	case errors.Is(err, database.ErrNamespaceNotEmpty):
+		return codes.FailedPrecondition
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, database.ErrTooManyRetries), errors.Is(err, io.EOF), errors.As(err, &netErr):
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// toStatusError converts an error into a gRPC status error, errors already carrying a status are returned unchanged
+func toStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var validationErr *database.RouteValidationError
+	if errors.As(err, &validationErr) {
+		return routeProblemsStatus(err, validationErr.Problems)
+	}
+
+	code := errorCode(err)
+	if code == codes.Internal {
+		logger.Errorw("Internal error handling request", "error", err)
+	}
+
+	return status.Error(code, err.Error())
+}
+
+// routeProblemsStatus reports the problems of an invalid route as field violations of the route data
+func routeProblemsStatus(err error, problems []*database.RouteProblem) error {
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(problems))
+	for _, problem := range problems {
+		field := "data"
+		if problem.Step >= 0 {
+			field = fmt.Sprintf("data.steps[%v]", problem.Step)
+		}
+		if problem.Reference != "" {
+			field = fmt.Sprintf("%s.references[%s]", field, problem.Reference)
+		}
+
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: problem.Description,
+		})
+	}
+
+	st := status.New(codes.InvalidArgument, err.Error())
+	detailed, detailErr := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if detailErr != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
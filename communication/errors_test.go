@@ -0,0 +1,101 @@
+package communication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/kulycloud/storage-redis/database"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{name: "not found", err: database.ErrorNotFound, code: codes.NotFound},
+		{name: "redis nil", err: redis.Nil, code: codes.NotFound},
+		{name: "invalid uid", err: database.ErrInvalidUid, code: codes.InvalidArgument},
+		{name: "invalid route", err: database.ErrInvalidRoute, code: codes.InvalidArgument},
+		{name: "route validation", err: &database.RouteValidationError{}, code: codes.InvalidArgument},
+		{name: "invalid request", err: ErrInvalidRequest, code: codes.InvalidArgument},
+		{name: "host conflict", err: database.ErrHostConflict, code: codes.AlreadyExists},
+		{name: "namespace exists", err: database.ErrNamespaceExists, code: codes.AlreadyExists},
+		{name: "quota exceeded", err: &database.QuotaExceededError{Resource: "routes", Limit: 1}, code: codes.ResourceExhausted},
+		{name: "namespace not empty", err: database.ErrNamespaceNotEmpty, code: codes.FailedPrecondition},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, code: codes.DeadlineExceeded},
+		{name: "canceled", err: context.Canceled, code: codes.Canceled},
+		{name: "too many retries", err: database.ErrTooManyRetries, code: codes.Unavailable},
+		{name: "connection closed", err: io.EOF, code: codes.Unavailable},
+		{name: "network error", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, code: codes.Unavailable},
+		{name: "wrapped", err: fmt.Errorf("could not get route: %w", database.ErrorNotFound), code: codes.NotFound},
+		{name: "redis error", err: redis.ErrClosed, code: codes.Internal},
+		{name: "unknown", err: errors.New("unknown"), code: codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := errorCode(tt.err); code != tt.code {
+				t.Errorf("errorCode(%v) = %v, want %v", tt.err, code, tt.code)
+			}
+		})
+	}
+}
+
+func TestToStatusError(t *testing.T) {
+	if err := toStatusError(nil); err != nil {
+		t.Errorf("toStatusError(nil) = %v, want nil", err)
+	}
+
+	existing := status.Error(codes.PermissionDenied, "denied")
+	if err := toStatusError(existing); err != existing {
+		t.Errorf("status error was changed to %v", err)
+	}
+
+	err := toStatusError(fmt.Errorf("could not set route: %w", database.ErrHostConflict))
+	if code := status.Code(err); code != codes.AlreadyExists {
+		t.Errorf("status code is %v, want %v", code, codes.AlreadyExists)
+	}
+}
+
+func TestToStatusErrorRouteProblems(t *testing.T) {
+	err := toStatusError(fmt.Errorf("could not set route: %w", &database.RouteValidationError{
+		Problems: []*database.RouteProblem{
+			{Step: -1, Description: "has no steps"},
+			{Step: 1, Description: "references an unknown service"},
+			{Step: 0, Reference: "next", Description: "references an unknown step"},
+		},
+	}))
+
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Errorf("status code is %v, want %v", st.Code(), codes.InvalidArgument)
+	}
+	if len(st.Details()) != 1 {
+		t.Fatalf("status has details %v, want a single bad request", st.Details())
+	}
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	if !ok {
+		t.Fatalf("status detail is %T, want a bad request", st.Details()[0])
+	}
+
+	fields := []string{"data", "data.steps[1]", "data.steps[0].references[next]"}
+	if len(badRequest.FieldViolations) != len(fields) {
+		t.Fatalf("got violations %v, want fields %v", badRequest.FieldViolations, fields)
+	}
+	for i, field := range fields {
+		if violation := badRequest.FieldViolations[i]; violation.Field != field {
+			t.Errorf("violation %v has field %s, want %s", i, violation.Field, field)
+		}
+	}
+	if description := badRequest.FieldViolations[1].Description; description != "references an unknown service" {
+		t.Errorf("violation has description %q", description)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	protoStorage "github.com/kulycloud/protocol/storage"
 	"github.com/kulycloud/storage-redis/config"
 	"github.com/kulycloud/storage-redis/database"
+	"github.com/kulycloud/storage-redis/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var ControlPlane *commonCommunication.ControlPlaneCommunicator
@@ -21,10 +23,10 @@ var logger = logging.GetForComponent("communication")
 var _ protoStorage.StorageServer = &StorageHandler{}
 type StorageHandler struct {
 	protoStorage.UnimplementedStorageServer
-	dbConnector *database.Connector
+	dbConnector database.Store
 }
 
-func NewStorageHandler(dbConnector *database.Connector) *StorageHandler {
+func NewStorageHandler(dbConnector database.Store) *StorageHandler {
 	return &StorageHandler{
 		dbConnector: dbConnector,
 	}
@@ -34,16 +36,35 @@ func (handler *StorageHandler) Register(listener *commonCommunication.Listener)
 	protoStorage.RegisterStorageServer(listener.Server, handler)
 }
 
-func (handler *StorageHandler) SetRoute(ctx context.Context, request *protoStorage.SetRouteRequest) (*protoStorage.SetRouteResponse, error) {
+func (handler *StorageHandler) SetRoute(ctx context.Context, request *protoStorage.SetRouteRequest) (response *protoStorage.SetRouteResponse, err error) {
+	ctx, finish := startRequest(ctx, "SetRoute")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.namespacedName("namespacedName", request.NamespacedName)
+	v.route("data", request.Data)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
 	uid, err := handler.dbConnector.SetRoute(ctx, request.NamespacedName, request.Data)
 	if err != nil {
-		return nil, fmt.Errorf("could not set route: %w", err)
+		return nil, toStatusError(fmt.Errorf("could not set route: %w", err))
 	}
 
 	return &protoStorage.SetRouteResponse{Uid: uid}, nil
 }
 
-func (handler *StorageHandler) GetRoute(ctx context.Context, request *protoStorage.GetRouteRequest) (*protoStorage.GetRouteResponse, error) {
+func (handler *StorageHandler) GetRoute(ctx context.Context, request *protoStorage.GetRouteRequest) (response *protoStorage.GetRouteResponse, err error) {
+	ctx, finish := startRequest(ctx, "GetRoute")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.routeId(request.Id)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
 	var uid string
 	namespacedName := &protoStorage.NamespacedName{}
 
@@ -53,29 +74,38 @@ func (handler *StorageHandler) GetRoute(ctx context.Context, request *protoStora
 		var err error
 		namespacedName, err = database.ParseUid(uid)
 		if err != nil {
-			return nil, err
+			return nil, toStatusError(err)
 		}
 	case *protoStorage.GetRouteRequest_NamespacedName:
 		var err error
 		uid, err = handler.dbConnector.GetRouteUidLatestRevision(ctx, val.NamespacedName)
 		namespacedName = val.NamespacedName
 		if err != nil {
-			return nil, fmt.Errorf("route not found: %w", ErrInvalidRequest)
+			return nil, toStatusError(fmt.Errorf("route not found: %w", err))
 		}
 	default:
-		return nil, fmt.Errorf("id is invalid: %w", ErrInvalidRequest)
+		return nil, toStatusError(fmt.Errorf("id is invalid: %w", ErrInvalidRequest))
 	}
 
 	route := &protoStorage.Route{}
-	err := handler.dbConnector.GetRoute(ctx, uid, route)
+	err = handler.dbConnector.GetRoute(ctx, uid, route)
 	if err != nil {
-		return nil, fmt.Errorf("could not get route: %w", err)
+		return nil, toStatusError(fmt.Errorf("could not get route: %w", err))
 	}
 
 	return &protoStorage.GetRouteResponse{Route: &protoStorage.RouteWithId{Uid: uid, Route: route, Name: namespacedName}}, nil
 }
 
-func (handler *StorageHandler) GetRouteStep(ctx context.Context, request *protoStorage.GetRouteStepRequest) (*protoStorage.GetRouteStepResponse, error) {
+func (handler *StorageHandler) GetRouteStep(ctx context.Context, request *protoStorage.GetRouteStepRequest) (response *protoStorage.GetRouteStepResponse, err error) {
+	ctx, finish := startRequest(ctx, "GetRouteStep")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.routeId(request.Id)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
 	var uid string
 	switch val := request.Id.(type) {
 	case *protoStorage.GetRouteStepRequest_Uid:
@@ -84,22 +114,31 @@ func (handler *StorageHandler) GetRouteStep(ctx context.Context, request *protoS
 		var err error
 		uid, err = handler.dbConnector.GetRouteUidLatestRevision(ctx, val.NamespacedName)
 		if err != nil {
-			return nil, fmt.Errorf("route not found: %w", ErrInvalidRequest)
+			return nil, toStatusError(fmt.Errorf("route not found: %w", err))
 		}
 	default:
-		return nil, fmt.Errorf("id is invalid: %w", ErrInvalidRequest)
+		return nil, toStatusError(fmt.Errorf("id is invalid: %w", ErrInvalidRequest))
 	}
 
 	step := &protoStorage.RouteStep{}
-	err := handler.dbConnector.GetRouteStep(ctx, uid, request.StepId, step)
+	err = handler.dbConnector.GetRouteStep(ctx, uid, request.StepId, step)
 	if err != nil {
-		return nil, fmt.Errorf("could not get step: %w", err)
+		return nil, toStatusError(fmt.Errorf("could not get step: %w", err))
 	}
 
 	return &protoStorage.GetRouteStepResponse{Step: step}, nil
 }
 
-func (handler *StorageHandler) GetPopulatedRouteStep(ctx context.Context, request *protoStorage.GetRouteStepRequest) (*protoStorage.GetPopulatedRouteStepResponse, error) {
+func (handler *StorageHandler) GetPopulatedRouteStep(ctx context.Context, request *protoStorage.GetRouteStepRequest) (response *protoStorage.GetPopulatedRouteStepResponse, err error) {
+	ctx, finish := startRequest(ctx, "GetPopulatedRouteStep")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.routeId(request.Id)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
 	var uid string
 	switch val := request.Id.(type) {
 	case *protoStorage.GetRouteStepRequest_Uid:
@@ -108,64 +147,46 @@ func (handler *StorageHandler) GetPopulatedRouteStep(ctx context.Context, reques
 		var err error
 		uid, err = handler.dbConnector.GetRouteUidLatestRevision(ctx, val.NamespacedName)
 		if err != nil {
-			return nil, fmt.Errorf("route not found: %w", ErrInvalidRequest)
+			return nil, toStatusError(fmt.Errorf("route not found: %w", err))
 		}
 	default:
-		return nil, fmt.Errorf("id is invalid: %w", ErrInvalidRequest)
+		return nil, toStatusError(fmt.Errorf("id is invalid: %w", ErrInvalidRequest))
 	}
 
-	step := &protoStorage.RouteStep{}
-	err := handler.dbConnector.GetRouteStep(ctx, uid, request.StepId, step)
+	populatedStep, err := handler.dbConnector.GetPopulatedRouteStep(ctx, uid, request.StepId)
 	if err != nil {
-		return nil, fmt.Errorf("could not get step: %w", err)
-	}
-
-	populatedStep := &protoStorage.PopulatedRouteStep{
-		Service:    step.Service,
-		Config:     step.Config,
-		Name: 		step.Name,
-		References: make(map[string]*protoStorage.PopulatedRouteStepReference),
+		return nil, toStatusError(fmt.Errorf("could not get step: %w", err))
 	}
 
-	routeStep := &protoStorage.RouteStep{}
-
-	for name, stepId := range step.References {
-		err = handler.dbConnector.GetRouteStep(ctx, uid, stepId, routeStep)
-		if err != nil {
-			return nil, err
-		}
+	return &protoStorage.GetPopulatedRouteStepResponse{Step: populatedStep}, nil
+}
 
-		endpoints, err := handler.dbConnector.GetEndpoints(ctx, database.ServiceLBEndpoints, routeStep.Service)
-		if err != nil {
-			return nil, err
-		}
+func (handler *StorageHandler) GetRouteStart(ctx context.Context, request *protoStorage.GetRouteStartRequest) (response *protoStorage.GetRouteStartResponse, err error) {
+	ctx, finish := startRequest(ctx, "GetRouteStart")
+	defer func() { finish(err) }()
 
-		populatedStep.References[name] = &protoStorage.PopulatedRouteStepReference{
-			Step: stepId,
-			Endpoints: endpoints.Endpoints,
-		}
+	v := newValidator()
+	v.host("host", request.Host)
+	if err := v.err(); err != nil {
+		return nil, err
 	}
 
-	return &protoStorage.GetPopulatedRouteStepResponse{Step: populatedStep}, nil
-}
-
-func (handler *StorageHandler) GetRouteStart(ctx context.Context, request *protoStorage.GetRouteStartRequest) (*protoStorage.GetRouteStartResponse, error) {
 	uid, err := handler.dbConnector.GetRouteUidByHost(ctx, request.Host)
 
 	if err != nil {
-		return nil, fmt.Errorf("could not get route by host: %w", err)
+		return nil, toStatusError(fmt.Errorf("could not get route by host: %w", err))
 	}
 
 	step := protoStorage.RouteStep{}
 	err = handler.dbConnector.GetRouteStep(ctx, uid, 0, &step)
 
 	if err != nil {
-		return nil, fmt.Errorf("could not get route by host: %w", err)
+		return nil, toStatusError(fmt.Errorf("could not get route by host: %w", err))
 	}
 
 	endpoints, err := handler.dbConnector.GetEndpoints(ctx, database.ServiceLBEndpoints, step.Service)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching endpoints for route: %w", err)
+		return nil, toStatusError(fmt.Errorf("error fetching endpoints for route: %w", err))
 	}
 
 	return &protoStorage.GetRouteStartResponse{
@@ -177,11 +198,20 @@ func (handler *StorageHandler) GetRouteStart(ctx context.Context, request *proto
 	}, nil
 }
 
-func (handler *StorageHandler) GetRoutesInNamespace(ctx context.Context, request *protoStorage.GetRoutesInNamespaceRequest) (*protoStorage.GetRoutesInNamespaceResponse, error) {
+func (handler *StorageHandler) GetRoutesInNamespace(ctx context.Context, request *protoStorage.GetRoutesInNamespaceRequest) (response *protoStorage.GetRoutesInNamespaceResponse, err error) {
+	ctx, finish := startRequest(ctx, "GetRoutesInNamespace")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.label("namespace", request.Namespace)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
 	routes, err := handler.dbConnector.GetRoutesInNamespace(ctx, request.Namespace)
 
 	if err != nil {
-		return nil, fmt.Errorf("could not get routes: %w", err)
+		return nil, toStatusError(fmt.Errorf("could not get routes: %w", err))
 	}
 
 	return &protoStorage.GetRoutesInNamespaceResponse{
@@ -189,35 +219,77 @@ func (handler *StorageHandler) GetRoutesInNamespace(ctx context.Context, request
 	}, nil
 }
 
-func (handler *StorageHandler) DeleteRoute(ctx context.Context, request *protoStorage.DeleteRouteRequest) (*protoCommon.Empty, error) {
-	return &protoCommon.Empty{}, handler.dbConnector.DeleteRoute(ctx, request.NamespacedName)
+func (handler *StorageHandler) DeleteRoute(ctx context.Context, request *protoStorage.DeleteRouteRequest) (response *protoCommon.Empty, err error) {
+	ctx, finish := startRequest(ctx, "DeleteRoute")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.namespacedName("namespacedName", request.NamespacedName)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	err = handler.dbConnector.DeleteRoute(ctx, request.NamespacedName)
+	if err != nil {
+		return nil, toStatusError(fmt.Errorf("could not delete route: %w", err))
+	}
+
+	return &protoCommon.Empty{}, nil
 }
 
-func (handler *StorageHandler) SetService(ctx context.Context, request *protoStorage.SetServiceRequest) (*protoCommon.Empty, error) {
-	err := handler.dbConnector.SetService(ctx, request.NamespacedName, request.Service)
+func (handler *StorageHandler) SetService(ctx context.Context, request *protoStorage.SetServiceRequest) (response *protoCommon.Empty, err error) {
+	ctx, finish := startRequest(ctx, "SetService")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.namespacedName("namespacedName", request.NamespacedName)
+	v.service("service", request.Service)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	err = handler.dbConnector.SetService(ctx, request.NamespacedName, request.Service)
 	if err != nil {
-		return nil, fmt.Errorf("could not set service: %w", err)
+		return nil, toStatusError(fmt.Errorf("could not set service: %w", err))
 	}
 
 	return &protoCommon.Empty{}, nil
 }
 
-func (handler *StorageHandler) GetService(ctx context.Context, request *protoStorage.GetServiceRequest) (*protoStorage.GetServiceResponse, error) {
+func (handler *StorageHandler) GetService(ctx context.Context, request *protoStorage.GetServiceRequest) (response *protoStorage.GetServiceResponse, err error) {
+	ctx, finish := startRequest(ctx, "GetService")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.namespacedName("namespacedName", request.NamespacedName)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
 	var service = &protoStorage.Service{}
-	err := handler.dbConnector.GetService(ctx, request.NamespacedName, service)
+	err = handler.dbConnector.GetService(ctx, request.NamespacedName, service)
 
 	if err != nil {
-		return nil, fmt.Errorf("could not get service: %w", err)
+		return nil, toStatusError(fmt.Errorf("could not get service: %w", err))
 	}
 
 	return &protoStorage.GetServiceResponse{Service: service}, nil
 }
 
-func (handler *StorageHandler) GetServicesInNamespace(ctx context.Context, request *protoStorage.GetServicesInNamespaceRequest) (*protoStorage.GetServicesInNamespaceResponse, error) {
+func (handler *StorageHandler) GetServicesInNamespace(ctx context.Context, request *protoStorage.GetServicesInNamespaceRequest) (response *protoStorage.GetServicesInNamespaceResponse, err error) {
+	ctx, finish := startRequest(ctx, "GetServicesInNamespace")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.label("namespace", request.Namespace)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
 	routes, err := handler.dbConnector.GetServicesInNamespace(ctx, request.Namespace)
 
 	if err != nil {
-		return nil, fmt.Errorf("could not get services: %w", err)
+		return nil, toStatusError(fmt.Errorf("could not get services: %w", err))
 	}
 
 	return &protoStorage.GetServicesInNamespaceResponse{
@@ -225,28 +297,73 @@ func (handler *StorageHandler) GetServicesInNamespace(ctx context.Context, reque
 	}, nil
 }
 
-func (handler *StorageHandler) GetServiceLBEndpoints(ctx context.Context, name *protoStorage.NamespacedName) (*protoCommon.EndpointList, error) {
-	return handler.dbConnector.GetEndpoints(ctx, database.ServiceLBEndpoints, name)
+func (handler *StorageHandler) GetServiceLBEndpoints(ctx context.Context, name *protoStorage.NamespacedName) (response *protoCommon.EndpointList, err error) {
+	ctx, finish := startRequest(ctx, "GetServiceLBEndpoints")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.namespacedName("name", name)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	endpoints, err := handler.dbConnector.GetEndpoints(ctx, database.ServiceLBEndpoints, name)
+	if err != nil {
+		return nil, toStatusError(fmt.Errorf("could not get endpoints: %w", err))
+	}
+
+	return endpoints, nil
 }
 
-func (handler *StorageHandler) SetServiceLBEndpoints(ctx context.Context, request *protoStorage.SetServiceLBEndpointsRequest) (*protoCommon.Empty, error) {
-	return &protoCommon.Empty{}, handler.dbConnector.SetEndpoints(ctx, database.ServiceLBEndpoints, request.ServiceName, &protoCommon.EndpointList{Endpoints: request.Endpoints})
+func (handler *StorageHandler) SetServiceLBEndpoints(ctx context.Context, request *protoStorage.SetServiceLBEndpointsRequest) (response *protoCommon.Empty, err error) {
+	ctx, finish := startRequest(ctx, "SetServiceLBEndpoints")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.namespacedName("serviceName", request.ServiceName)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	err = handler.dbConnector.SetEndpoints(ctx, database.ServiceLBEndpoints, request.ServiceName, &protoCommon.EndpointList{Endpoints: request.Endpoints})
+	if err != nil {
+		return nil, toStatusError(fmt.Errorf("could not set endpoints: %w", err))
+	}
+
+	return &protoCommon.Empty{}, nil
 }
 
-func (handler *StorageHandler) DeleteService(ctx context.Context, request *protoStorage.DeleteServiceRequest) (*protoCommon.Empty, error) {
-	return &protoCommon.Empty{}, handler.dbConnector.DeleteService(ctx, request.NamespacedName)
+func (handler *StorageHandler) DeleteService(ctx context.Context, request *protoStorage.DeleteServiceRequest) (response *protoCommon.Empty, err error) {
+	ctx, finish := startRequest(ctx, "DeleteService")
+	defer func() { finish(err) }()
+
+	v := newValidator()
+	v.namespacedName("namespacedName", request.NamespacedName)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	err = handler.dbConnector.DeleteService(ctx, request.NamespacedName)
+	if err != nil {
+		return nil, toStatusError(fmt.Errorf("could not delete service: %w", err))
+	}
+
+	return &protoCommon.Empty{}, nil
 }
 
-func (handler *StorageHandler) GetNamespaces(ctx context.Context, _ *protoCommon.Empty) (*protoStorage.NamespaceList, error) {
+func (handler *StorageHandler) GetNamespaces(ctx context.Context, _ *protoCommon.Empty) (response *protoStorage.NamespaceList, err error) {
+	ctx, finish := startRequest(ctx, "GetNamespaces")
+	defer func() { finish(err) }()
+
 	namespaces, err := handler.dbConnector.GetNamespaces(ctx)
 	if err != nil {
-		return nil, err
+		return nil, toStatusError(err)
 	}
 
 	return &protoStorage.NamespaceList{Namespaces: namespaces}, nil
 }
 
-func RegisterToControlPlane(dbConnector *database.Connector) {
+func RegisterToControlPlane(dbConnector database.Store, checker *health.Checker) {
 	communicator := commonCommunication.RegisterToControlPlane("storage",
 		config.GlobalConfig.Host, config.GlobalConfig.Port,
 		config.GlobalConfig.ControlPlaneHost, config.GlobalConfig.ControlPlanePort, false)
@@ -259,6 +376,7 @@ func RegisterToControlPlane(dbConnector *database.Connector) {
 
 	handler := NewStorageHandler(dbConnector)
 	handler.Register(listener)
+	healthpb.RegisterHealthServer(listener.Server, checker.Server)
 
 	serveErr := listener.Serve()
 	ControlPlane = <-communicator
@@ -0,0 +1,24 @@
+package communication
+
+import (
+	"context"
+	"github.com/kulycloud/storage-redis/database"
+	"github.com/kulycloud/storage-redis/metrics"
+	"github.com/kulycloud/storage-redis/tracing"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// startRequest prepares the context of an RPC. The returned function has to be called with the result of the RPC.
+func startRequest(ctx context.Context, method string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracing.StartRpcSpan(ctx, method)
+	ctx, cancel := database.WithRequestTimeout(ctx)
+
+	return ctx, func(err error) {
+		cancel()
+		code := status.Code(err)
+		metrics.ObserveRpc(method, code, time.Since(start))
+		tracing.EndRpcSpan(span, code, err)
+	}
+}
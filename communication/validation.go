@@ -0,0 +1,139 @@
+package communication
+
+import (
+	"fmt"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/database"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"regexp"
+	"strings"
+)
+
+// Namespaces and names follow the DNS label rules (RFC 1123)
+var labelRegex = regexp.MustCompile("^[a-z0-9]([-a-z0-9]*[a-z0-9])?$")
+
+const maxLabelLength = 63
+
+// validator collects all violations of a request so they can be reported at once
+type validator struct {
+	violations []*errdetails.BadRequest_FieldViolation
+}
+
+func newValidator() *validator {
+	return &validator{
+		violations: make([]*errdetails.BadRequest_FieldViolation, 0),
+	}
+}
+
+func (v *validator) fail(field string, format string, args ...interface{}) {
+	v.violations = append(v.violations, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) label(field string, value string) {
+	if value == "" {
+		v.fail(field, "must not be empty")
+	} else if len(value) > maxLabelLength {
+		v.fail(field, "must not be longer than %v characters", maxLabelLength)
+	} else if !labelRegex.MatchString(value) {
+		v.fail(field, "must consist of lower case alphanumeric characters or '-' and start and end with an alphanumeric character")
+	}
+}
+
+func (v *validator) namespacedName(field string, name *protoStorage.NamespacedName) {
+	if name == nil {
+		v.fail(field, "is required")
+		return
+	}
+
+	v.label(field+".namespace", name.Namespace)
+	v.label(field+".name", name.Name)
+}
+
+func (v *validator) uid(field string, uid string) {
+	name, err := database.ParseUid(uid)
+	if err != nil {
+		v.fail(field, "must have the format <namespace>:<name>@<revision>")
+		return
+	}
+
+	v.namespacedName(field, name)
+}
+
+// routeId validates the id oneof of route requests
+func (v *validator) routeId(id interface{}) {
+	switch val := id.(type) {
+	case *protoStorage.GetRouteRequest_Uid:
+		v.uid("uid", val.Uid)
+	case *protoStorage.GetRouteRequest_NamespacedName:
+		v.namespacedName("namespacedName", val.NamespacedName)
+	case *protoStorage.GetRouteStepRequest_Uid:
+		v.uid("uid", val.Uid)
+	case *protoStorage.GetRouteStepRequest_NamespacedName:
+		v.namespacedName("namespacedName", val.NamespacedName)
+	default:
+		v.fail("id", "is required")
+	}
+}
+
+func (v *validator) host(field string, host string) {
+	if host == "" {
+		v.fail(field, "must not be empty")
+	} else if strings.ContainsAny(host, "/ \t\r\n") {
+		v.fail(field, "must not contain '/' or whitespace")
+	}
+}
+
+func (v *validator) route(field string, route *protoStorage.Route) {
+	if route == nil {
+		v.fail(field, "is required")
+		return
+	}
+
+	v.host(field+".host", route.Host)
+
+	for i, step := range route.Steps {
+		stepField := fmt.Sprintf("%s.steps[%v]", field, i)
+		if step == nil {
+			v.fail(stepField, "is required")
+			continue
+		}
+
+		v.namespacedName(stepField+".service", step.Service)
+		for name, reference := range step.References {
+			if int(reference) >= len(route.Steps) {
+				v.fail(fmt.Sprintf("%s.references[%s]", stepField, name), "references step %v but route only has %v steps", reference, len(route.Steps))
+			}
+		}
+	}
+}
+
+func (v *validator) service(field string, service *protoStorage.Service) {
+	if service == nil {
+		v.fail(field, "is required")
+	}
+}
+
+// err returns an InvalidArgument status listing all violations or nil if the request is valid
+func (v *validator) err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, 0, len(v.violations))
+	for _, violation := range v.violations {
+		descriptions = append(descriptions, violation.Field+" "+violation.Description)
+	}
+
+	st := status.New(codes.InvalidArgument, fmt.Sprintf("%s: %s", ErrInvalidRequest, strings.Join(descriptions, "; ")))
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: v.violations})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
@@ -1,24 +1,112 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	commonConfig "github.com/kulycloud/common/config"
 )
 
 type Config struct {
 	Host             string `configName:"host"`
 	Port             uint32 `configName:"port"`
-	RedisAddress     string `configName:"redisAddress"`
-	RedisPassword    string `configName:"redisPassword"`
+	RedisAddress     string `configName:"redisAddress" defaultValue:""`
+	RedisPassword    string `configName:"redisPassword" defaultValue:""`
 	ControlPlaneHost string `configName:"controlPlaneHost"`
 	ControlPlanePort uint32 `configName:"controlPlanePort"`
+	// Number of route revisions kept besides the latest one, 0 keeps all
+	RouteRevisionsKept uint32 `configName:"routeRevisionsKept" defaultValue:"0"`
+	// Maximum age of non-latest route revisions in seconds, 0 disables age based removal
+	RouteRevisionMaxAge uint32 `configName:"routeRevisionMaxAge" defaultValue:"0"`
+	// Interval of the revision garbage collection in seconds, 0 disables the background collection
+	RevisionGcInterval uint32 `configName:"revisionGcInterval" defaultValue:"300"`
+	// Allow route steps to reference each other in cycles
+	AllowRouteCycles bool `configName:"allowRouteCycles" defaultValue:"false"`
+	// Redis 6 ACL user, empty uses the default user
+	RedisUsername              string `configName:"redisUsername" defaultValue:""`
+	RedisTls                   bool   `configName:"redisTls" defaultValue:"false"`
+	RedisTlsCaFile             string `configName:"redisTlsCaFile" defaultValue:""`
+	RedisTlsCertFile           string `configName:"redisTlsCertFile" defaultValue:""`
+	RedisTlsKeyFile            string `configName:"redisTlsKeyFile" defaultValue:""`
+	RedisTlsServerName         string `configName:"redisTlsServerName" defaultValue:""`
+	RedisTlsInsecureSkipVerify bool   `configName:"redisTlsInsecureSkipVerify" defaultValue:"false"`
+	// Connection pool and timeouts, 0 uses the defaults of go-redis. Timeouts are in milliseconds.
+	RedisPoolSize     uint32 `configName:"redisPoolSize" defaultValue:"0"`
+	RedisMinIdleConns uint32 `configName:"redisMinIdleConns" defaultValue:"0"`
+	RedisPoolTimeout  uint32 `configName:"redisPoolTimeout" defaultValue:"0"`
+	RedisDialTimeout  uint32 `configName:"redisDialTimeout" defaultValue:"0"`
+	RedisReadTimeout  uint32 `configName:"redisReadTimeout" defaultValue:"0"`
+	RedisWriteTimeout uint32 `configName:"redisWriteTimeout" defaultValue:"0"`
+	// Retries of failed commands, -1 disables retries
+	RedisMaxRetries int32 `configName:"redisMaxRetries" defaultValue:"0"`
+	// Deadline in milliseconds for the redis operations of a single request, 0 only uses the deadline of the caller
+	RedisRequestTimeout uint32 `configName:"redisRequestTimeout" defaultValue:"0"`
+	// Initial connection attempts with exponential backoff. 0 retries forever.
+	ConnectMaxAttempts uint32 `configName:"connectMaxAttempts" defaultValue:"0"`
+	// Time in seconds after which connecting is given up, 0 retries forever
+	ConnectDeadline uint32 `configName:"connectDeadline" defaultValue:"0"`
+	// Backoff between connection attempts in milliseconds
+	ConnectInitialBackoff uint32 `configName:"connectInitialBackoff" defaultValue:"500"`
+	ConnectMaxBackoff     uint32 `configName:"connectMaxBackoff" defaultValue:"30000"`
+	// Either "single", "sentinel" or "cluster"
+	RedisMode              string   `configName:"redisMode" defaultValue:"single"`
+	RedisMasterName        string   `configName:"redisMasterName" defaultValue:""`
+	RedisSentinelAddresses []string `configName:"redisSentinelAddresses" defaultValue:""`
+	RedisClusterAddresses  []string `configName:"redisClusterAddresses" defaultValue:""`
+	// Hash tag of the keys shared by all namespaces in cluster mode, keys of a namespace are tagged with its name
+	RedisKeyTag string `configName:"redisKeyTag" defaultValue:"kuly"`
+	// Port of the HTTP health endpoints
+	HealthPort uint32 `configName:"healthPort" defaultValue:"8080"`
+	// Interval of the storage health checks in seconds
+	HealthCheckInterval uint32 `configName:"healthCheckInterval" defaultValue:"5"`
+	// Port of the prometheus metrics endpoint, 0 disables metrics
+	MetricsPort uint32 `configName:"metricsPort" defaultValue:"9090"`
+	// Interval of counting stored objects for metrics in seconds
+	MetricsStatsInterval uint32 `configName:"metricsStatsInterval" defaultValue:"60"`
+	// Address of the OTLP collector, tracing is disabled if empty
+	TracingEndpoint    string  `configName:"tracingEndpoint" defaultValue:""`
+	TracingInsecure    bool    `configName:"tracingInsecure" defaultValue:"false"`
+	TracingSampleRatio float64 `configName:"tracingSampleRatio" defaultValue:"1"`
+	// Either "redis" or "memory", the in-memory backend is only meant for tests and local development
+	StorageBackend string `configName:"storageBackend" defaultValue:"redis"`
+	// Maximum number of entries of each in-process read cache, 0 disables caching
+	CacheSize uint32 `configName:"cacheSize" defaultValue:"10000"`
+	// Lifetime of cached host resolutions, latest revisions and endpoints in milliseconds
+	CacheTtl uint32 `configName:"cacheTtl" defaultValue:"1000"`
+	// Use redis client-side caching to invalidate cached data exactly instead of relying on the cache ttl.
+	// Falls back to the ttl based cache if the server does not support it, with a cacheTtl of 0 those reads go to redis.
+	RedisClientTracking bool `configName:"redisClientTracking" defaultValue:"false"`
+	// Either "json" or "protobuf", json keeps stored objects readable by older versions
+	StorageEncoding string `configName:"storageEncoding" defaultValue:"json"`
+	// Compress larger stored objects, only applies to the protobuf encoding
+	StorageCompression bool `configName:"storageCompression" defaultValue:"false"`
 }
 
 var GlobalConfig = &Config{}
 
+var ErrInvalidConfig = errors.New("invalid config")
+
 func ParseConfig() error {
 	parser := commonConfig.NewParser()
 	parser.AddProvider(commonConfig.NewCliParamProvider())
 	parser.AddProvider(commonConfig.NewEnvironmentVariableProvider())
 
-	return parser.Populate(GlobalConfig)
+	err := parser.Populate(GlobalConfig)
+	if err != nil {
+		return err
+	}
+	return validate()
+}
+
+// validate rejects values the parser accepts but that would only fail at runtime
+func validate() error {
+	if GlobalConfig.HealthCheckInterval == 0 {
+		return fmt.Errorf("%w: healthCheckInterval must be at least 1", ErrInvalidConfig)
+	}
+	if GlobalConfig.MetricsPort > 0 && GlobalConfig.MetricsStatsInterval == 0 {
+		return fmt.Errorf("%w: metricsStatsInterval must be at least 1", ErrInvalidConfig)
+	}
+	if GlobalConfig.StorageEncoding != "json" && GlobalConfig.StorageEncoding != "protobuf" {
+		return fmt.Errorf("%w: storageEncoding must be either json or protobuf, got %q", ErrInvalidConfig, GlobalConfig.StorageEncoding)
+	}
+	return nil
 }
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/config"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// lruCache is a size limited cache evicting the least recently used entry. Entries with a ttl of 0 never expire.
+type lruCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+func newLruCache(maxEntries int) *lruCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (cache *lruCache) Get(key string) (interface{}, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		cache.removeElement(element)
+		return nil, false
+	}
+
+	cache.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (cache *lruCache) Add(key string, value interface{}, ttl time.Duration) {
+	if cache.maxEntries <= 0 {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry := &cacheEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+
+	if element, ok := cache.entries[key]; ok {
+		element.Value = entry
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(entry)
+	if cache.order.Len() > cache.maxEntries {
+		cache.removeElement(cache.order.Back())
+	}
+}
+
+func (cache *lruCache) Remove(key string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		cache.removeElement(element)
+	}
+}
+
+func (cache *lruCache) RemovePrefix(prefix string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for key, element := range cache.entries {
+		if strings.HasPrefix(key, prefix) {
+			cache.removeElement(element)
+		}
+	}
+}
+
+func (cache *lruCache) Purge() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.entries = make(map[string]*list.Element)
+	cache.order.Init()
+}
+
+func (cache *lruCache) removeElement(element *list.Element) {
+	cache.order.Remove(element)
+	delete(cache.entries, element.Value.(*cacheEntry).key)
+}
+
+// readCache keeps data read from redis in process memory.
+// Route revisions are immutable and cached until they are deleted, all other data is cached for ttl
+// and invalidated by change events.
+type readCache struct {
+	// Keyed by uid followed by "/", only removed when the route is deleted
+	revisions *lruCache
+	// Host resolutions, any route change may affect them
+	requests *lruCache
+	// Latest revisions and endpoints keyed by their redis key
+	pointers *lruCache
+	ttl      time.Duration
+	// Set while redis client-side caching invalidates requests and pointers exactly, they are cached without ttl then
+	tracking int32
+	// Incremented on every tracking invalidation so values read before it are not cached without ttl
+	generation uint64
+}
+
+func newReadCache() *readCache {
+	size := int(config.GlobalConfig.CacheSize)
+	return &readCache{
+		revisions: newLruCache(size),
+		requests:  newLruCache(size),
+		pointers:  newLruCache(size),
+		ttl:       milliseconds(config.GlobalConfig.CacheTtl),
+	}
+}
+
+// uidPrefix is the common prefix of the uids of all revisions of a route
+func uidPrefix(namespacedName *protoStorage.NamespacedName) string {
+	return namespacedName.Namespace + ":" + namespacedName.Name + "@"
+}
+
+func revisionCacheKey(uid string, kind string) string {
+	return uid + "/" + kind
+}
+
+func requestCacheKey(host string, path string, method string) string {
+	return method + " " + host + " " + path
+}
+
+func (cache *readCache) currentGeneration() uint64 {
+	return atomic.LoadUint64(&cache.generation)
+}
+
+// addMutable caches a value of requests or pointers read at generation
+func (cache *readCache) addMutable(target *lruCache, key string, value interface{}, generation uint64) {
+	if atomic.LoadInt32(&cache.tracking) == 1 {
+		target.Add(key, value, 0)
+		// The key may have been invalidated while it was read or added
+		if cache.currentGeneration() != generation {
+			target.Remove(key)
+		}
+		return
+	}
+
+	if cache.ttl > 0 {
+		target.Add(key, value, cache.ttl)
+	}
+}
+
+// invalidate removes all entries affected by event
+func (cache *readCache) invalidate(event *Event) {
+	switch event.Kind {
+	case RouteEvent:
+		if event.Type == Pruned {
+			// The latest revision is never pruned, so host resolutions are not affected
+			cache.revisions.RemovePrefix(revisionCacheKey(event.Uid, ""))
+			return
+		}
+		cache.requests.Purge()
+		cache.pointers.Remove(dbLatestRevisionName(event.NamespacedName))
+		if event.Type == Deleted {
+			cache.revisions.RemovePrefix(uidPrefix(event.NamespacedName))
+		}
+	case EndpointsEvent:
+		cache.pointers.Remove(dbEndpointsName(event.EndpointType, event.NamespacedName))
+	}
+}
+
+// RunCacheInvalidation applies the change events of all replicas to the read cache until the context is cancelled.
+// Events published while the subscription reconnects are lost, those entries expire after the cache ttl.
+// Revisions pruned meanwhile have no ttl and stay readable from the cache until they are evicted.
+// Revisions are never reused, so a cached revision of a deleted route is never returned for a newer one.
+func (connector *Connector) RunCacheInvalidation(ctx context.Context) {
+	for event := range connector.Watch(ctx) {
+		connector.cache.invalidate(event)
+	}
+}
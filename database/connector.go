@@ -3,36 +3,230 @@ package database
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/kulycloud/common/logging"
 	"github.com/kulycloud/storage-redis/config"
+	"github.com/kulycloud/storage-redis/metrics"
+	"strings"
+	"time"
 )
 
 var logger = logging.GetForComponent("database")
 
 var ErrorNotFound = errors.New("not found")
+var ErrInvalidRedisMode = errors.New("invalid redis mode")
+var ErrTooManyRetries = errors.New("too many concurrent modifications")
+
+// clusterMode is set when connected to a redis cluster. Transactions and scripts may only access keys of a single
+// slot there, so keys carry a hash tag: keys of a namespace are tagged with the namespace, keys shared by all
+// namespaces with the configured key tag.
+var clusterMode = false
+
+// dbGlobalKey names keys shared by all namespaces like the host index
+func dbGlobalKey(key string) string {
+	if !clusterMode {
+		return key
+	}
+	return "{" + config.GlobalConfig.RedisKeyTag + "}" + key
+}
+
+// dbNamespaceKey names keys only holding data of a single namespace
+func dbNamespaceKey(namespace string, key string) string {
+	if !clusterMode {
+		return key
+	}
+	return "{" + namespace + "}" + key
+}
+
+// dbKeyPattern matches all keys starting with prefix regardless of their hash tag
+func dbKeyPattern(prefix string) string {
+	if !clusterMode {
+		return prefix + "*"
+	}
+	return "{*}" + prefix + "*"
+}
+
+// untaggedKey removes the hash tag of a key
+func untaggedKey(key string) string {
+	if !clusterMode || !strings.HasPrefix(key, "{") {
+		return key
+	}
+	return key[strings.Index(key, "}")+1:]
+}
+
+// maxTxRetries limits how often an optimistic transaction is retried when a watched key was modified concurrently
+const maxTxRetries = 100
 
 type Connector struct {
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
+	cache       *readCache
 }
 
 func NewConnector() *Connector {
-	return &Connector{}
+	return &Connector{
+		cache: newReadCache(),
+	}
+}
+
+func nonEmpty(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+func milliseconds(value uint32) time.Duration {
+	return time.Duration(value) * time.Millisecond
+}
+
+// newRedisClient creates a client for the configured deployment, onConnect is run on every new connection if set
+func newRedisClient(onConnect func(ctx context.Context, cn *redis.Conn) error) (redis.UniversalClient, error) {
+	tlsConfig, err := newTlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	options := &redis.UniversalOptions{
+		Username:  config.GlobalConfig.RedisUsername,
+		Password:  config.GlobalConfig.RedisPassword,
+		TLSConfig: tlsConfig,
+
+		PoolSize:     int(config.GlobalConfig.RedisPoolSize),
+		MinIdleConns: int(config.GlobalConfig.RedisMinIdleConns),
+		PoolTimeout:  milliseconds(config.GlobalConfig.RedisPoolTimeout),
+		DialTimeout:  milliseconds(config.GlobalConfig.RedisDialTimeout),
+		ReadTimeout:  milliseconds(config.GlobalConfig.RedisReadTimeout),
+		WriteTimeout: milliseconds(config.GlobalConfig.RedisWriteTimeout),
+		MaxRetries:   int(config.GlobalConfig.RedisMaxRetries),
+
+		OnConnect: onConnect,
+	}
+
+	switch config.GlobalConfig.RedisMode {
+	case "single":
+		options.Addrs = []string{config.GlobalConfig.RedisAddress}
+		clusterMode = false
+		return redis.NewClient(options.Simple()), nil
+	case "sentinel":
+		options.Addrs = nonEmpty(config.GlobalConfig.RedisSentinelAddresses)
+		options.MasterName = config.GlobalConfig.RedisMasterName
+		clusterMode = false
+		return redis.NewFailoverClient(options.Failover()), nil
+	case "cluster":
+		options.Addrs = nonEmpty(config.GlobalConfig.RedisClusterAddresses)
+		clusterMode = true
+		return redis.NewClusterClient(options.Cluster()), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRedisMode, config.GlobalConfig.RedisMode)
+	}
 }
 
 func (connector *Connector) Connect() error {
-	client  := redis.NewClient(&redis.Options{
-		Addr: config.GlobalConfig.RedisAddress,
-		Password: config.GlobalConfig.RedisPassword,
-	})
+	client, err := newRedisClient(nil)
+	if err != nil {
+		return err
+	}
 
-	_, err := client.Ping(context.TODO()).Result()
+	_, err = client.Ping(context.TODO()).Result()
 	if err != nil {
+		_ = client.Close()
 		return err
 	}
 
+	metrics.InstrumentRedis(client)
 	connector.redisClient = client
 	logger.Info("Connected to DB")
 
 	return nil
 }
+
+func (connector *Connector) Ping(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "Ping")
+	defer span.End()
+
+	return connector.redisClient.Ping(ctx).Err()
+}
+
+// watch runs fn as an optimistic transaction on the given keys and retries it if one of them was modified concurrently
+func (connector *Connector) watch(ctx context.Context, fn func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < maxTxRetries; i++ {
+		err := connector.redisClient.Watch(ctx, fn, keys...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return ErrTooManyRetries
+}
+
+// txPart is the part of a transaction accessing keys of a single slot in cluster mode
+type txPart struct {
+	// Watched before check is run, the first key selects the slot in cluster mode
+	keys []string
+	// check reads the state of the part, it may watch further keys of the same slot before reading them.
+	// Returns the writes of the part, which may be nil.
+	check func(tx *redis.Tx) (func(p redis.Pipeliner), error)
+}
+
+// transaction runs parts as a single optimistic transaction, all checks are run before any write.
+// Redis cluster cannot run transactions across slots, so every part is committed on its own in the given order there.
+// Parts are ordered so that a part failing after the previous ones have been committed leaves valid data behind.
+func (connector *Connector) transaction(ctx context.Context, parts ...txPart) error {
+	if !clusterMode {
+		keys := make([]string, 0)
+		for _, part := range parts {
+			keys = append(keys, part.keys...)
+		}
+		return connector.watch(ctx, func(tx *redis.Tx) error {
+			return runTxParts(ctx, tx, parts)
+		}, keys...)
+	}
+
+	for _, part := range parts {
+		err := connector.watch(ctx, func(tx *redis.Tx) error {
+			return runTxParts(ctx, tx, []txPart{part})
+		}, part.keys...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runTxParts(ctx context.Context, tx *redis.Tx, parts []txPart) error {
+	writes := make([]func(p redis.Pipeliner), 0, len(parts))
+	for _, part := range parts {
+		write, err := part.check(tx)
+		if err != nil {
+			return err
+		}
+		if write != nil {
+			writes = append(writes, write)
+		}
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+
+	_, err := tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for _, write := range writes {
+			write(p)
+		}
+		return nil
+	})
+	return err
+}
+
+// WithRequestTimeout limits the redis operations of a request to the configured request timeout.
+// Deadlines of the caller are kept if they are earlier.
+func WithRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if config.GlobalConfig.RedisRequestTimeout == 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, milliseconds(config.GlobalConfig.RedisRequestTimeout))
+}
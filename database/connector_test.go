@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/config"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestConnector connects to the redis at REDIS_ADDRESS, the test is skipped if it is not reachable
+func newTestConnector(tb testing.TB) *Connector {
+	tb.Helper()
+
+	address := os.Getenv("REDIS_ADDRESS")
+	if address == "" {
+		address = "localhost:6379"
+	}
+	config.GlobalConfig.RedisMode = "single"
+	config.GlobalConfig.RedisAddress = address
+	config.GlobalConfig.StorageEncoding = "json"
+
+	client, err := newRedisClient(nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = client.Ping(ctx).Err()
+	if err != nil {
+		_ = client.Close()
+		tb.Skipf("redis at %s is not reachable: %v", address, err)
+	}
+	tb.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	connector := NewConnector()
+	connector.redisClient = client
+	return connector
+}
+
+// newTestNamespace returns a namespace only used by the calling test, it is deleted with all contents afterwards
+func newTestNamespace(tb testing.TB, connector *Connector) string {
+	tb.Helper()
+
+	namespace := fmt.Sprintf("test-%x", time.Now().UnixNano())
+	tb.Cleanup(func() {
+		ctx := context.Background()
+		err := connector.DeleteNamespace(ctx, namespace, true)
+		if err != nil && err != ErrorNotFound {
+			tb.Errorf("could not delete namespace %s: %v", namespace, err)
+		}
+		// Revision counters outlive their routes and are only removed for tests
+		err = connector.redisClient.Del(ctx, dbRouteRevisionCountersName(namespace)).Err()
+		if err != nil {
+			tb.Errorf("could not delete revision counters of namespace %s: %v", namespace, err)
+		}
+	})
+	return namespace
+}
+
+func newTestService(tb testing.TB, connector *Connector, namespace string, name string) *protoStorage.NamespacedName {
+	tb.Helper()
+
+	namespacedName := &protoStorage.NamespacedName{Namespace: namespace, Name: name}
+	err := connector.SetService(context.Background(), namespacedName, &protoStorage.Service{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return namespacedName
+}
+
+// newTestRoute returns a route forwarding all requests of host to service
+func newTestRoute(host string, service *protoStorage.NamespacedName) *protoStorage.Route {
+	return &protoStorage.Route{
+		Host:  host,
+		Steps: []*protoStorage.RouteStep{{Service: service}},
+	}
+}
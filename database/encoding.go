@@ -0,0 +1,189 @@
+package database
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/kulycloud/storage-redis/config"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io/ioutil"
+	"strings"
+)
+
+var ErrUnknownFormat = errors.New("unknown storage format")
+var ErrUnknownEncoding = errors.New("unknown storage encoding")
+
+// Stored objects start with a format byte followed by the encoded object.
+// Values written before the envelope existed are plain JSON objects and always start with '{'.
+const (
+	formatProtobuf           byte = 1
+	formatCompressedProtobuf byte = 2
+	formatLegacyJson         byte = '{'
+)
+
+// Smaller values are never compressed, the flate overhead would outweigh the savings
+const minCompressedSize = 256
+
+// wrap puts data into an envelope, compressing it if enabled and worthwhile
+func wrap(data []byte) (string, error) {
+	if config.GlobalConfig.StorageCompression && len(data) >= minCompressedSize {
+		var buf bytes.Buffer
+		buf.WriteByte(formatCompressedProtobuf)
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return "", err
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return "", err
+		}
+		err = w.Close()
+		if err != nil {
+			return "", err
+		}
+		if buf.Len() < len(data)+1 {
+			return buf.String(), nil
+		}
+	}
+
+	return string(formatProtobuf) + string(data), nil
+}
+
+// unwrap returns the protobuf wire encoding of an envelope, ok is false for legacy JSON values
+func unwrap(str string) (data []byte, ok bool, err error) {
+	if len(str) == 0 {
+		return nil, false, ErrUnknownFormat
+	}
+
+	switch str[0] {
+	case formatProtobuf:
+		return []byte(str[1:]), true, nil
+	case formatCompressedProtobuf:
+		data, err := ioutil.ReadAll(flate.NewReader(strings.NewReader(str[1:])))
+		if err != nil {
+			return nil, false, fmt.Errorf("could not decompress value: %w", err)
+		}
+		return data, true, nil
+	case formatLegacyJson:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("%w: %v", ErrUnknownFormat, str[0])
+	}
+}
+
+// isLegacyFormat reports whether a value has been written before the envelope existed
+func isLegacyFormat(str string) bool {
+	return len(str) > 0 && str[0] == formatLegacyJson
+}
+
+// encodeMessage serializes a message in the configured storage encoding
+func encodeMessage(message proto.Message) (string, error) {
+	switch config.GlobalConfig.StorageEncoding {
+	case "json":
+		m := jsonpb.Marshaler{}
+		return m.MarshalToString(message)
+	case "protobuf":
+		data, err := proto.Marshal(message)
+		if err != nil {
+			return "", err
+		}
+		return wrap(data)
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownEncoding, config.GlobalConfig.StorageEncoding)
+	}
+}
+
+// decodeMessage deserializes a message stored in any supported format
+func decodeMessage(str string, message proto.Message) error {
+	data, ok, err := unwrap(str)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return jsonpb.Unmarshal(strings.NewReader(str), message)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+// dbRoute has no message definition, its fields are encoded manually in protobuf wire format
+const (
+	dbRouteHostField       protowire.Number = 1
+	dbRoutePathPrefixField protowire.Number = 2
+	dbRouteMethodsField    protowire.Number = 3
+)
+
+func encodeDbRoute(route *dbRoute) (string, error) {
+	switch config.GlobalConfig.StorageEncoding {
+	case "json":
+		str, err := json.Marshal(route)
+		return string(str), err
+	case "protobuf":
+		var data []byte
+		data = appendStringField(data, dbRouteHostField, route.Host)
+		data = appendStringField(data, dbRoutePathPrefixField, route.PathPrefix)
+		for _, method := range route.Methods {
+			data = protowire.AppendTag(data, dbRouteMethodsField, protowire.BytesType)
+			data = protowire.AppendString(data, method)
+		}
+		return wrap(data)
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownEncoding, config.GlobalConfig.StorageEncoding)
+	}
+}
+
+func appendStringField(data []byte, number protowire.Number, value string) []byte {
+	if value == "" {
+		return data
+	}
+	data = protowire.AppendTag(data, number, protowire.BytesType)
+	return protowire.AppendString(data, value)
+}
+
+func decodeDbRoute(str string, route *dbRoute) error {
+	data, ok, err := unwrap(str)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return json.Unmarshal([]byte(str), route)
+	}
+
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if wireType != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(number, wireType, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeString(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		// Unknown fields are skipped so newer versions may add fields
+		switch number {
+		case dbRouteHostField:
+			route.Host = value
+		case dbRoutePathPrefixField:
+			route.PathPrefix = value
+		case dbRouteMethodsField:
+			route.Methods = append(route.Methods, value)
+		}
+	}
+
+	return nil
+}
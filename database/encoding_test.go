@@ -0,0 +1,294 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	protoCommon "github.com/kulycloud/protocol/common"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/config"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setStorageEncoding configures the storage encoding for the calling test and restores it afterwards
+func setStorageEncoding(tb testing.TB, encoding string, compression bool) {
+	tb.Helper()
+
+	oldEncoding, oldCompression := config.GlobalConfig.StorageEncoding, config.GlobalConfig.StorageCompression
+	tb.Cleanup(func() {
+		config.GlobalConfig.StorageEncoding, config.GlobalConfig.StorageCompression = oldEncoding, oldCompression
+	})
+	config.GlobalConfig.StorageEncoding = encoding
+	config.GlobalConfig.StorageCompression = compression
+}
+
+func newEncodingTestService() *protoStorage.Service {
+	return &protoStorage.Service{
+		Image:       "ghcr.io/kuly/example:latest",
+		Replicas:    3,
+		Environment: map[string]string{"LOG_LEVEL": "debug"},
+		// Repetitive and long enough to be compressed
+		Arguments: []string{strings.Repeat("--verbose ", 40)},
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		encoding    string
+		compression bool
+		format      byte
+	}{
+		{name: "json", encoding: "json", format: formatLegacyJson},
+		{name: "protobuf", encoding: "protobuf", format: formatProtobuf},
+		{name: "compressed", encoding: "protobuf", compression: true, format: formatCompressedProtobuf},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setStorageEncoding(t, tt.encoding, tt.compression)
+			service := newEncodingTestService()
+
+			str, err := encodeMessage(service)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if str[0] != tt.format {
+				t.Errorf("encoded value starts with %v, want %v", str[0], tt.format)
+			}
+
+			decoded := &protoStorage.Service{}
+			err = decodeMessage(str, decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(decoded, service) {
+				t.Errorf("decoded %v, want %v", decoded, service)
+			}
+		})
+	}
+}
+
+func TestEncodeSmallMessageUncompressed(t *testing.T) {
+	setStorageEncoding(t, "protobuf", true)
+
+	str, err := encodeMessage(&protoStorage.Service{Image: "example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str[0] != formatProtobuf {
+		t.Errorf("small value starts with %v, want %v", str[0], formatProtobuf)
+	}
+}
+
+func TestEncodeUnknownEncoding(t *testing.T) {
+	setStorageEncoding(t, "yaml", false)
+
+	_, err := encodeMessage(newEncodingTestService())
+	if !errors.Is(err, ErrUnknownEncoding) {
+		t.Errorf("encodeMessage returned %v, want %v", err, ErrUnknownEncoding)
+	}
+	_, err = encodeDbRoute(&dbRoute{Host: "example.com"})
+	if !errors.Is(err, ErrUnknownEncoding) {
+		t.Errorf("encodeDbRoute returned %v, want %v", err, ErrUnknownEncoding)
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  *protoStorage.Service
+		err   error
+	}{
+		{
+			name:  "legacy json",
+			value: `{"image":"example","replicas":2}`,
+			want:  &protoStorage.Service{Image: "example", Replicas: 2},
+		},
+		{
+			name:  "empty protobuf message",
+			value: "\x01",
+			want:  &protoStorage.Service{},
+		},
+		{
+			name:  "unknown format",
+			value: "\x07abc",
+			err:   ErrUnknownFormat,
+		},
+		{
+			name:  "empty value",
+			value: "",
+			err:   ErrUnknownFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &protoStorage.Service{}
+			err := decodeMessage(tt.value, service)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("decodeMessage returned %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(service, tt.want) {
+				t.Errorf("decoded %v, want %v", service, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeDbRoute(t *testing.T) {
+	route := &dbRoute{Host: "example.com", RouteMatch: RouteMatch{PathPrefix: "/api", Methods: []string{"GET", "POST"}}}
+
+	for _, encoding := range []string{"json", "protobuf"} {
+		t.Run(encoding, func(t *testing.T) {
+			setStorageEncoding(t, encoding, false)
+
+			str, err := encodeDbRoute(route)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := &dbRoute{}
+			err = decodeDbRoute(str, decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, route) {
+				t.Errorf("decoded %+v, want %+v", decoded, route)
+			}
+		})
+	}
+}
+
+func TestConverters(t *testing.T) {
+	service := newEncodingTestService()
+	route := &dbRoute{Host: "example.com", RouteMatch: RouteMatch{PathPrefix: "/api"}}
+	convertService := messageConverter(func() proto.Message { return &protoStorage.Service{} })
+
+	tests := []struct {
+		from string
+		to   string
+	}{
+		{from: "json", to: "protobuf"},
+		{from: "protobuf", to: "json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			setStorageEncoding(t, tt.from, false)
+			storedService, err := encodeMessage(service)
+			if err != nil {
+				t.Fatal(err)
+			}
+			storedRoute, err := encodeDbRoute(route)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config.GlobalConfig.StorageEncoding = tt.to
+			if !needsMigration(storedService) || !needsMigration(storedRoute) {
+				t.Fatalf("values stored in %s do not need migration to %s", tt.from, tt.to)
+			}
+
+			converted, err := convertService(storedService)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if needsMigration(converted) {
+				t.Error("converted service still needs migration")
+			}
+			decoded := &protoStorage.Service{}
+			err = decodeMessage(converted, decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(decoded, service) {
+				t.Errorf("converted service decoded to %v, want %v", decoded, service)
+			}
+
+			converted, err = convertDbRoute(storedRoute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if needsMigration(converted) {
+				t.Error("converted route still needs migration")
+			}
+			decodedRoute := &dbRoute{}
+			err = decodeDbRoute(converted, decodedRoute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decodedRoute, route) {
+				t.Errorf("converted route decoded to %+v, want %+v", decodedRoute, route)
+			}
+		})
+	}
+
+	_, err := convertService("\x07abc")
+	if !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("converting an unknown format returned %v, want %v", err, ErrUnknownFormat)
+	}
+}
+
+func TestMigrateStorageEncoding(t *testing.T) {
+	connector := newTestConnector(t)
+	ctx := context.Background()
+	namespace := newTestNamespace(t, connector)
+	setStorageEncoding(t, "json", false)
+	service := newTestService(t, connector, namespace, "backend")
+	_, err := connector.SetRoute(ctx, &protoStorage.NamespacedName{Namespace: namespace, Name: "route"}, newTestRoute(namespace+".example.com", service))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = connector.SetEndpoints(ctx, ServiceLBEndpoints, service, &protoCommon.EndpointList{Endpoints: []*protoCommon.Endpoint{{Host: "10.0.0.1", Port: 8080}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the given namespace is migrated
+	other := newTestService(t, connector, newTestNamespace(t, connector), "backend")
+
+	config.GlobalConfig.StorageEncoding = "protobuf"
+	count, err := connector.MigrateStorageEncoding(ctx, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Service, route, route steps and endpoint list
+	if count != 4 {
+		t.Errorf("migrated %v keys, want 4", count)
+	}
+
+	str, err := connector.redisClient.Get(ctx, dbServiceName(service)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str[0] != formatProtobuf {
+		t.Errorf("migrated service starts with %v, want %v", str[0], formatProtobuf)
+	}
+	err = connector.GetService(ctx, service, &protoStorage.Service{})
+	if err != nil {
+		t.Errorf("migrated service could not be read: %v", err)
+	}
+	str, err = connector.redisClient.Get(ctx, dbServiceName(other)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isLegacyFormat(str) {
+		t.Error("service of another namespace was migrated")
+	}
+
+	count, err = connector.MigrateStorageEncoding(ctx, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("second migration rewrote %v keys, want 0", count)
+	}
+}
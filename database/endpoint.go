@@ -4,10 +4,9 @@ import (
 	"context"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	protoCommon "github.com/kulycloud/protocol/common"
 	protoStorage "github.com/kulycloud/protocol/storage"
-	"strings"
 )
 
 type EndpointType string
@@ -17,39 +16,116 @@ const (
 )
 
 func dbEndpointsName(endpointType EndpointType, name *protoStorage.NamespacedName) string {
-	return fmt.Sprintf("endpoints/%s/%s:%s", endpointType, name.Namespace, name.Name)
+	return dbNamespaceKey(name.Namespace, fmt.Sprintf("endpoints/%s/%s:%s", endpointType, name.Namespace, name.Name))
+}
+
+func dbNamespaceEndpointsName(namespace string) string {
+	return dbNamespaceKey(namespace, "endpoint-lists/"+namespace)
+}
+
+func dbNamespaceEndpointsMember(endpointType EndpointType, name string) string {
+	return fmt.Sprintf("%s/%s", endpointType, name)
 }
 
 func (connector *Connector) SetEndpoints(ctx context.Context, endpointType EndpointType, name *protoStorage.NamespacedName, endpoints *protoCommon.EndpointList) error {
+	ctx, span := startSpan(ctx, "SetEndpoints")
+	defer span.End()
+
+	event := &Event{Kind: EndpointsEvent, NamespacedName: name, EndpointType: endpointType}
+
+	endpointsKey := dbNamespaceEndpointsName(name.Namespace)
 	if endpoints.Endpoints == nil || len(endpoints.Endpoints) == 0 {
-		return connector.redisClient.Del(ctx, dbEndpointsName(endpointType, name)).Err()
+		event.Type = Deleted
+		err := connector.transaction(ctx, txPart{
+			keys: []string{endpointsKey},
+			check: func(*redis.Tx) (func(p redis.Pipeliner), error) {
+				return func(p redis.Pipeliner) {
+					p.Del(ctx, dbEndpointsName(endpointType, name))
+					p.SRem(ctx, endpointsKey, dbNamespaceEndpointsMember(endpointType, name.Name))
+					publishEvent(ctx, p, event)
+				}, nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+		connector.cache.invalidate(event)
+		return connector.DeleteNamespaceIfEmpty(ctx, name.Namespace)
+	}
+
+	str, err := encodeMessage(endpoints)
+	if err != nil {
+		return fmt.Errorf("could not serialize endpoints: %w", err)
 	}
 
-	m := jsonpb.Marshaler{}
-	str, err := m.MarshalToString(endpoints)
+	event.Type = Updated
+	err = connector.transaction(ctx, txPart{
+		keys: []string{endpointsKey, dbNamespaceQuotaName(name.Namespace)},
+		check: func(tx *redis.Tx) (func(p redis.Pipeliner), error) {
+			quota, err := getQuota(ctx, tx, name.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			err = checkLimit("endpoints", quota.MaxEndpoints, int64(len(endpoints.Endpoints)))
+			if err != nil {
+				return nil, err
+			}
+
+			return func(p redis.Pipeliner) {
+				p.Set(ctx, dbEndpointsName(endpointType, name), str, 0)
+				p.SAdd(ctx, endpointsKey, dbNamespaceEndpointsMember(endpointType, name.Name))
+			}, nil
+		},
+	}, txPart{
+		keys: []string{dbGlobalKey(dbNamespacesName)},
+		check: func(*redis.Tx) (func(p redis.Pipeliner), error) {
+			return func(p redis.Pipeliner) {
+				connector.AddNamespaceIfNotExistsTx(ctx, p, name.Namespace)
+				publishEvent(ctx, p, event)
+			}, nil
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("could not serialize json: %w", err)
+		return err
 	}
 
-	return connector.redisClient.Set(ctx, dbEndpointsName(endpointType, name), str, 0).Err()
+	connector.cache.invalidate(event)
+	return nil
 }
 
 func (connector *Connector) GetEndpoints(ctx context.Context, endpointType EndpointType, name *protoStorage.NamespacedName) (*protoCommon.EndpointList, error) {
+	ctx, span := startSpan(ctx, "GetEndpoints")
+	defer span.End()
 
-	str, err := connector.redisClient.Get(ctx, dbEndpointsName(endpointType, name)).Result()
 
-	el := &protoCommon.EndpointList{}
+	key := dbEndpointsName(endpointType, name)
+	if cached, ok := connector.cache.pointers.Get(key); ok {
+		return proto.Clone(cached.(*protoCommon.EndpointList)).(*protoCommon.EndpointList), nil
+	}
+
+	generation := connector.cache.currentGeneration()
+	el := &protoCommon.EndpointList{Endpoints: []*protoCommon.Endpoint{}}
+	str, err := connector.redisClient.Get(ctx, key).Result()
 	if err != nil {
 		if err != redis.Nil {
 			return nil, err
 		}
-		el.Endpoints = []*protoCommon.Endpoint {}
 	} else {
-		err = jsonpb.Unmarshal(strings.NewReader(str), el)
+		el, err = parseEndpoints(str)
 		if err != nil {
-			return nil, fmt.Errorf("could not deserialize json: %w", err)
+			return nil, err
 		}
 	}
 
+	connector.cache.addMutable(connector.cache.pointers, key, proto.Clone(el), generation)
+	return el, nil
+}
+
+func parseEndpoints(str string) (*protoCommon.EndpointList, error) {
+	el := &protoCommon.EndpointList{}
+	err := decodeMessage(str, el)
+	if err != nil {
+		return nil, fmt.Errorf("could not deserialize endpoints: %w", err)
+	}
 	return el, nil
 }
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	protoStorage "github.com/kulycloud/protocol/storage"
+)
+
+// All storage replicas publish their changes to this channel so watchers see every write
+const dbEventsChannel = "events"
+
+type EventKind string
+
+const (
+	RouteEvent     EventKind = "route"
+	ServiceEvent   EventKind = "service"
+	EndpointsEvent EventKind = "endpoints"
+)
+
+type EventType string
+
+const (
+	Created EventType = "created"
+	Updated EventType = "updated"
+	Deleted EventType = "deleted"
+	// Only used for route revisions removed by the retention policy, the route itself is unchanged
+	Pruned EventType = "pruned"
+)
+
+type Event struct {
+	Kind           EventKind                    `json:"kind"`
+	Type           EventType                    `json:"type"`
+	NamespacedName *protoStorage.NamespacedName `json:"namespacedName"`
+	// Only set for route events
+	Uid      string `json:"uid,omitempty"`
+	Revision uint64 `json:"revision,omitempty"`
+	// Only set for endpoint events
+	EndpointType EndpointType `json:"endpointType,omitempty"`
+}
+
+func publishEvent(ctx context.Context, client redis.Cmdable, event *Event) {
+	str, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorw("Could not serialize event", "error", err)
+		return
+	}
+
+	client.Publish(ctx, dbEventsChannel, str)
+}
+
+// Watch streams all change events until the context is cancelled.
+// Events are only delivered to watchers connected at the time of the change.
+func (connector *Connector) Watch(ctx context.Context) <-chan *Event {
+	pubsub := connector.redisClient.Subscribe(ctx, dbEventsChannel)
+	events := make(chan *Event)
+
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				event := &Event{}
+				err := json.Unmarshal([]byte(msg.Payload), event)
+				if err != nil {
+					logger.Warnw("Received invalid event", "error", err)
+					continue
+				}
+
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return events
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/config"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidRoute = errors.New("invalid route")
+
+// routeLevel is used as step index of problems concerning the route as a whole
+const routeLevel = -1
+
+type RouteProblem struct {
+	// Index of the step the problem was found in, -1 for problems of the whole route
+	Step int
+	// Name of the reference the problem was found in, empty if the step itself is affected
+	Reference   string
+	Description string
+}
+
+func (problem *RouteProblem) String() string {
+	if problem.Step == routeLevel {
+		return problem.Description
+	}
+	if problem.Reference == "" {
+		return fmt.Sprintf("step %v: %s", problem.Step, problem.Description)
+	}
+	return fmt.Sprintf("step %v reference %s: %s", problem.Step, problem.Reference, problem.Description)
+}
+
+type RouteValidationError struct {
+	Problems []*RouteProblem
+}
+
+func (err *RouteValidationError) Error() string {
+	descriptions := make([]string, 0, len(err.Problems))
+	for _, problem := range err.Problems {
+		descriptions = append(descriptions, problem.String())
+	}
+	return fmt.Sprintf("%s: %s", ErrInvalidRoute, strings.Join(descriptions, "; "))
+}
+
+func (err *RouteValidationError) Unwrap() error {
+	return ErrInvalidRoute
+}
+
+// sortedReferences returns the reference names of a step in a stable order
+func sortedReferences(step *protoStorage.RouteStep) []string {
+	names := make([]string, 0, len(step.References))
+	for name := range step.References {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkReferences(route *protoStorage.Route) []*RouteProblem {
+	problems := make([]*RouteProblem, 0)
+	for i, step := range route.Steps {
+		for _, name := range sortedReferences(step) {
+			if int(step.References[name]) >= len(route.Steps) {
+				problems = append(problems, &RouteProblem{
+					Step:        i,
+					Reference:   name,
+					Description: fmt.Sprintf("references missing step %v", step.References[name]),
+				})
+			}
+		}
+	}
+	return problems
+}
+
+// checkReachability reports all steps not reachable from the first step
+func checkReachability(route *protoStorage.Route) []*RouteProblem {
+	reached := make([]bool, len(route.Steps))
+	queue := []uint32{0}
+	reached[0] = true
+
+	for len(queue) > 0 {
+		step := route.Steps[queue[0]]
+		queue = queue[1:]
+		for _, next := range step.References {
+			if int(next) < len(route.Steps) && !reached[next] {
+				reached[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	problems := make([]*RouteProblem, 0)
+	for i := range route.Steps {
+		if !reached[i] {
+			problems = append(problems, &RouteProblem{Step: i, Description: "is not reachable from the first step"})
+		}
+	}
+	return problems
+}
+
+// checkCycles reports every reference closing a cycle in the step graph
+func checkCycles(route *protoStorage.Route) []*RouteProblem {
+	const (
+		unvisited = iota
+		active
+		done
+	)
+
+	problems := make([]*RouteProblem, 0)
+	state := make([]int, len(route.Steps))
+
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = active
+		step := route.Steps[i]
+		for _, name := range sortedReferences(step) {
+			next := int(step.References[name])
+			if next >= len(route.Steps) {
+				continue
+			}
+			switch state[next] {
+			case active:
+				problems = append(problems, &RouteProblem{
+					Step:        i,
+					Reference:   name,
+					Description: fmt.Sprintf("creates a cycle through step %v", next),
+				})
+			case unvisited:
+				visit(next)
+			}
+		}
+		state[i] = done
+	}
+
+	for i := range route.Steps {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+	return problems
+}
+
+// checkRouteGraph runs all checks not depending on stored data, they are shared by all stores
+func checkRouteGraph(route *protoStorage.Route) []*RouteProblem {
+	if len(route.Steps) == 0 {
+		return []*RouteProblem{{Step: routeLevel, Description: "route has no steps"}}
+	}
+
+	problems := checkReferences(route)
+	problems = append(problems, checkReachability(route)...)
+	if !config.GlobalConfig.AllowRouteCycles {
+		problems = append(problems, checkCycles(route)...)
+	}
+	for i, step := range route.Steps {
+		if step.Service == nil {
+			problems = append(problems, &RouteProblem{Step: i, Description: "has no service"})
+		}
+	}
+	return problems
+}
+
+func unknownServiceProblem(step int, service *protoStorage.NamespacedName) *RouteProblem {
+	return &RouteProblem{
+		Step:        step,
+		Description: fmt.Sprintf("references unknown service %s:%s", service.Namespace, service.Name),
+	}
+}
+
+// checkServices reports steps referencing services that do not exist, client may be a watching transaction.
+// Only services include returns true for are checked, all if include is nil.
+func checkServices(ctx context.Context, client redis.Cmdable, route *protoStorage.Route, include func(service *protoStorage.NamespacedName) bool) ([]*RouteProblem, error) {
+	problems := make([]*RouteProblem, 0)
+	p := client.Pipeline()
+	exists := make(map[int]*redis.IntCmd)
+
+	for i, step := range route.Steps {
+		if step.Service != nil && (include == nil || include(step.Service)) {
+			exists[i] = p.Exists(ctx, dbServiceName(step.Service))
+		}
+	}
+
+	if len(exists) == 0 {
+		return problems, nil
+	}
+
+	_, err := p.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, step := range route.Steps {
+		if cmd, ok := exists[i]; ok && cmd.Val() == 0 {
+			problems = append(problems, unknownServiceProblem(i, step.Service))
+		}
+	}
+	return problems, nil
+}
+
+// ValidateRoute checks the step graph of a route and returns all problems found.
+// An empty result means the route can be stored.
+func (connector *Connector) ValidateRoute(ctx context.Context, route *protoStorage.Route) ([]*RouteProblem, error) {
+	ctx, span := startSpan(ctx, "ValidateRoute")
+	defer span.End()
+
+	problems := checkRouteGraph(route)
+	serviceProblems, err := checkServices(ctx, connector.redisClient, route, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(problems, serviceProblems...), nil
+}
@@ -0,0 +1,253 @@
+package database
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"strings"
+)
+
+// anyMethod is used in host index fields of routes not restricted to specific methods
+const anyMethod = "*"
+
+// RouteMatch restricts a route to requests with a path prefix and specific methods.
+// An empty match makes the route the default route of its host.
+type RouteMatch struct {
+	PathPrefix string   `json:"pathPrefix,omitempty"`
+	Methods    []string `json:"methods,omitempty"`
+}
+
+func (match *RouteMatch) isDefault() bool {
+	return match.PathPrefix == "" && len(match.Methods) == 0
+}
+
+// hostEntry is a single entry in the host index. Default routes are stored in the plain host key,
+// all other routes in the matches hash of the host with one field per method and path prefix.
+type hostEntry struct {
+	host  string
+	field string
+}
+
+func dbHostMatchesName(host string) string {
+	return dbGlobalKey("hosts/" + host + "/matches")
+}
+
+func matchField(method string, pathPrefix string) string {
+	return method + " " + pathPrefix
+}
+
+func parseMatchField(field string) (string, string) {
+	parts := strings.SplitN(field, " ", 2)
+	if len(parts) != 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func (route *dbRoute) hostEntries() []hostEntry {
+	if route.isDefault() {
+		return []hostEntry{{host: route.Host}}
+	}
+
+	if len(route.Methods) == 0 {
+		return []hostEntry{{host: route.Host, field: matchField(anyMethod, route.PathPrefix)}}
+	}
+
+	entries := make([]hostEntry, 0, len(route.Methods))
+	for _, method := range route.Methods {
+		entries = append(entries, hostEntry{host: route.Host, field: matchField(strings.ToUpper(method), route.PathPrefix)})
+	}
+	return entries
+}
+
+func (entry hostEntry) key() string {
+	if entry.field == "" {
+		return dbHostRoute(entry.host)
+	}
+	return dbHostMatchesName(entry.host)
+}
+
+func (entry hostEntry) get(ctx context.Context, client redis.Cmdable) *redis.StringCmd {
+	if entry.field == "" {
+		return client.Get(ctx, entry.key())
+	}
+	return client.HGet(ctx, entry.key(), entry.field)
+}
+
+func (entry hostEntry) set(ctx context.Context, tx redis.Pipeliner, uid string) {
+	if entry.field == "" {
+		tx.Set(ctx, entry.key(), uid, 0)
+	} else {
+		tx.HSet(ctx, entry.key(), entry.field, uid)
+	}
+}
+
+func (entry hostEntry) del(ctx context.Context, tx redis.Pipeliner) {
+	if entry.field == "" {
+		tx.Del(ctx, entry.key())
+	} else {
+		tx.HDel(ctx, entry.key(), entry.field)
+	}
+}
+
+// ownsHostEntry checks whether the given host index entry is either unused or belongs to a revision of the given route
+func ownsHostEntry(ctx context.Context, client redis.Cmdable, entry hostEntry, namespacedName *protoStorage.NamespacedName) (bool, error) {
+	owner, err := entry.get(ctx, client).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return true, nil
+		}
+		return false, err
+	}
+
+	ownerName, err := ParseUid(owner)
+	if err != nil {
+		// Broken entries are free to be taken over
+		return true, nil
+	}
+
+	return isSameName(ownerName, namespacedName), nil
+}
+
+// ownedStaleEntries returns all entries of oldEntries not contained in newEntries that still belong to the given route.
+// Watches all returned entries if client is a transaction.
+func ownedStaleEntries(ctx context.Context, client redis.Cmdable, oldEntries []hostEntry, newEntries []hostEntry, namespacedName *protoStorage.NamespacedName) ([]hostEntry, error) {
+	current := make(map[hostEntry]bool)
+	for _, entry := range newEntries {
+		current[entry] = true
+	}
+
+	stale := make([]hostEntry, 0)
+	for _, entry := range oldEntries {
+		if current[entry] {
+			continue
+		}
+
+		if tx, ok := client.(*redis.Tx); ok {
+			err := tx.Watch(ctx, entry.key()).Err()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		owned, err := ownsHostEntry(ctx, client, entry, namespacedName)
+		if err != nil {
+			return nil, err
+		}
+		if owned {
+			stale = append(stale, entry)
+		}
+	}
+
+	return stale, nil
+}
+
+func matchesPath(path string, pathPrefix string) bool {
+	return path == pathPrefix || strings.HasSuffix(pathPrefix, "/") && strings.HasPrefix(path, pathPrefix) ||
+		strings.HasPrefix(path, pathPrefix+"/")
+}
+
+// bestMatch selects the most specific entry of a matches hash: the longest path prefix wins,
+// on equal prefixes a route for the specific method is preferred over one for any method
+func bestMatch(matches map[string]string, path string, method string) (string, bool) {
+	bestUid := ""
+	bestLen := -1
+	bestSpecific := false
+
+	for field, uid := range matches {
+		fieldMethod, pathPrefix := parseMatchField(field)
+		specific := fieldMethod != anyMethod
+		if specific && fieldMethod != strings.ToUpper(method) || !matchesPath(path, pathPrefix) {
+			continue
+		}
+
+		if len(pathPrefix) > bestLen || len(pathPrefix) == bestLen && specific && !bestSpecific {
+			bestUid = uid
+			bestLen = len(pathPrefix)
+			bestSpecific = specific
+		}
+	}
+
+	return bestUid, bestLen >= 0
+}
+
+// hostCandidates lists all hosts that may serve the given host ordered by precedence:
+// the exact host, wildcard hosts from the longest to the shortest suffix and finally the default route
+func hostCandidates(host string) []string {
+	candidates := []string{host}
+
+	labels := strings.Split(host, ".")
+	for i := 1; i < len(labels); i++ {
+		candidates = append(candidates, "*."+strings.Join(labels[i:], "."))
+	}
+
+	if host != DefaultRouteHost {
+		candidates = append(candidates, DefaultRouteHost)
+	}
+
+	return candidates
+}
+
+// GetRouteUidByRequest resolves the most specific route for a request with a single round-trip.
+// Host precedence is evaluated first, within a host the most specific match wins over the default route of the host.
+func (connector *Connector) GetRouteUidByRequest(ctx context.Context, host string, path string, method string) (string, error) {
+	ctx, span := startSpan(ctx, "GetRouteUidByRequest")
+	defer span.End()
+
+	cacheKey := requestCacheKey(host, path, method)
+	if uid, ok := connector.cache.requests.Get(cacheKey); ok {
+		return uid.(string), nil
+	}
+
+	candidates := hostCandidates(host)
+	keys := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		keys = append(keys, dbHostRoute(candidate))
+	}
+
+	generation := connector.cache.currentGeneration()
+	p := connector.redisClient.Pipeline()
+	defaults := p.MGet(ctx, keys...)
+	matches := make([]*redis.StringStringMapCmd, 0, len(candidates))
+	for _, candidate := range candidates {
+		matches = append(matches, p.HGetAll(ctx, dbHostMatchesName(candidate)))
+	}
+
+	_, err := p.Exec(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range candidates {
+		uid, ok := bestMatch(matches[i].Val(), path, method)
+		if !ok {
+			uid, ok = defaults.Val()[i].(string)
+		}
+		if ok {
+			connector.cache.addMutable(connector.cache.requests, cacheKey, uid, generation)
+			return uid, nil
+		}
+	}
+
+	return "", ErrorNotFound
+}
+
+// GetRouteUidByHost resolves the route serving the root path of a host
+func (connector *Connector) GetRouteUidByHost(ctx context.Context, host string) (string, error) {
+	ctx, span := startSpan(ctx, "GetRouteUidByHost")
+	defer span.End()
+
+	return connector.GetRouteUidByRequest(ctx, host, "/", "")
+}
+
+func (connector *Connector) GetRouteMatch(ctx context.Context, uid string) (*RouteMatch, error) {
+	ctx, span := startSpan(ctx, "GetRouteMatch")
+	defer span.End()
+
+	dbRoute, err := getDbRoute(ctx, connector.redisClient, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dbRoute.RouteMatch, nil
+}
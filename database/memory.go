@@ -0,0 +1,366 @@
+package database
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	protoCommon "github.com/kulycloud/protocol/common"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/metrics"
+	"sort"
+	"sync"
+)
+
+var _ Store = &MemoryStore{}
+var _ metrics.StatsSource = &MemoryStore{}
+
+// MemoryStore keeps all data in process memory. It is meant for tests and local development,
+// all data is lost on restart and it cannot be shared between replicas.
+type MemoryStore struct {
+	mutex sync.RWMutex
+	// Keyed by uid, contains all revisions
+	routes map[string]*protoStorage.Route
+	// Keyed by namespace and name
+	latestRevisions map[string]map[string]uint64
+	// Last allocated revision keyed by uid prefix, kept on delete so uids are never reused
+	revisionCounters map[string]uint64
+	services         map[string]map[string]*protoStorage.Service
+	hosts            map[string]string
+	// Keyed by namespace and endpoint list member
+	endpoints  map[string]map[string]*protoCommon.EndpointList
+	namespaces map[string]bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		routes:           make(map[string]*protoStorage.Route),
+		latestRevisions:  make(map[string]map[string]uint64),
+		revisionCounters: make(map[string]uint64),
+		services:         make(map[string]map[string]*protoStorage.Service),
+		hosts:            make(map[string]string),
+		endpoints:        make(map[string]map[string]*protoCommon.EndpointList),
+		namespaces:       make(map[string]bool),
+	}
+}
+
+func (store *MemoryStore) latestRevision(namespacedName *protoStorage.NamespacedName) (uint64, bool) {
+	revision, ok := store.latestRevisions[namespacedName.Namespace][namespacedName.Name]
+	return revision, ok
+}
+
+func (store *MemoryStore) service(namespacedName *protoStorage.NamespacedName) (*protoStorage.Service, bool) {
+	service, ok := store.services[namespacedName.Namespace][namespacedName.Name]
+	return service, ok
+}
+
+// copyInto replaces the content of dst with a deep copy of src so callers never share stored messages
+func copyInto(dst proto.Message, src proto.Message) {
+	dst.Reset()
+	proto.Merge(dst, src)
+}
+
+func (store *MemoryStore) validateRoute(route *protoStorage.Route) []*RouteProblem {
+	problems := checkRouteGraph(route)
+	for i, step := range route.Steps {
+		if step.Service == nil {
+			continue
+		}
+		if _, ok := store.service(step.Service); !ok {
+			problems = append(problems, unknownServiceProblem(i, step.Service))
+		}
+	}
+
+	return problems
+}
+
+func (store *MemoryStore) ownsHost(host string, namespacedName *protoStorage.NamespacedName) bool {
+	owner, ok := store.hosts[host]
+	if !ok {
+		return true
+	}
+
+	ownerName, err := ParseUid(owner)
+	return err != nil || isSameName(ownerName, namespacedName)
+}
+
+func (store *MemoryStore) SetRoute(_ context.Context, namespacedName *protoStorage.NamespacedName, route *protoStorage.Route) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	problems := store.validateRoute(route)
+	if len(problems) > 0 {
+		return "", &RouteValidationError{Problems: problems}
+	}
+
+	if !store.ownsHost(route.Host, namespacedName) {
+		return "", ErrHostConflict
+	}
+
+	revision, hasOldUid := store.latestRevision(namespacedName)
+	if hasOldUid {
+		oldRoute := store.routes[buildUid(namespacedName, revision)]
+		if oldRoute.Host != route.Host && store.ownsHost(oldRoute.Host, namespacedName) {
+			delete(store.hosts, oldRoute.Host)
+		}
+	}
+
+	revision = store.revisionCounters[uidPrefix(namespacedName)] + 1
+	store.revisionCounters[uidPrefix(namespacedName)] = revision
+	uid := buildUid(namespacedName, revision)
+	stored := &protoStorage.Route{}
+	copyInto(stored, route)
+
+	store.routes[uid] = stored
+	if store.latestRevisions[namespacedName.Namespace] == nil {
+		store.latestRevisions[namespacedName.Namespace] = make(map[string]uint64)
+	}
+	store.latestRevisions[namespacedName.Namespace][namespacedName.Name] = revision
+	store.hosts[route.Host] = uid
+	store.namespaces[namespacedName.Namespace] = true
+
+	return uid, nil
+}
+
+func (store *MemoryStore) GetRoute(_ context.Context, uid string, route *protoStorage.Route) error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	stored, ok := store.routes[uid]
+	if !ok {
+		return ErrorNotFound
+	}
+
+	copyInto(route, stored)
+	return nil
+}
+
+func (store *MemoryStore) GetRouteStep(_ context.Context, uid string, id uint32, step *protoStorage.RouteStep) error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	stored, ok := store.routes[uid]
+	if !ok || int(id) >= len(stored.Steps) {
+		return ErrorNotFound
+	}
+
+	copyInto(step, stored.Steps[id])
+	return nil
+}
+
+func (store *MemoryStore) GetPopulatedRouteStep(ctx context.Context, uid string, id uint32) (*protoStorage.PopulatedRouteStep, error) {
+	step := &protoStorage.RouteStep{}
+	err := store.GetRouteStep(ctx, uid, id, step)
+	if err != nil {
+		return nil, err
+	}
+
+	return populateRouteStep(ctx, store, uid, step)
+}
+
+func (store *MemoryStore) GetPopulatedRoute(ctx context.Context, uid string) (*PopulatedRoute, error) {
+	return populateRoute(ctx, store, uid)
+}
+
+func (store *MemoryStore) GetPopulatedRouteByHost(ctx context.Context, host string) (*PopulatedRoute, error) {
+	return populateRouteByHost(ctx, store, host)
+}
+
+func (store *MemoryStore) GetRouteUidLatestRevision(_ context.Context, namespacedName *protoStorage.NamespacedName) (string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	revision, ok := store.latestRevision(namespacedName)
+	if !ok {
+		return "", ErrorNotFound
+	}
+
+	return buildUid(namespacedName, revision), nil
+}
+
+func (store *MemoryStore) GetRouteUidByHost(_ context.Context, host string) (string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	for _, candidate := range hostCandidates(host) {
+		if uid, ok := store.hosts[candidate]; ok {
+			return uid, nil
+		}
+	}
+
+	return "", ErrorNotFound
+}
+
+func (store *MemoryStore) GetRoutesInNamespace(_ context.Context, namespace string) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.routesInNamespace(namespace), nil
+}
+
+func (store *MemoryStore) routesInNamespace(namespace string) []string {
+	uids := make([]string, 0)
+	for name, revision := range store.latestRevisions[namespace] {
+		uids = append(uids, buildUid(&protoStorage.NamespacedName{Namespace: namespace, Name: name}, revision))
+	}
+
+	sort.Strings(uids)
+	return uids
+}
+
+func (store *MemoryStore) DeleteRoute(_ context.Context, namespacedName *protoStorage.NamespacedName) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	revision, ok := store.latestRevision(namespacedName)
+	if !ok {
+		return ErrorNotFound
+	}
+
+	route := store.routes[buildUid(namespacedName, revision)]
+	if store.ownsHost(route.Host, namespacedName) {
+		delete(store.hosts, route.Host)
+	}
+
+	for rev := revision; rev > 0; rev-- {
+		delete(store.routes, buildUid(namespacedName, rev))
+	}
+	delete(store.latestRevisions[namespacedName.Namespace], namespacedName.Name)
+
+	store.deleteNamespaceIfEmpty(namespacedName.Namespace)
+	return nil
+}
+
+func (store *MemoryStore) SetService(_ context.Context, namespacedName *protoStorage.NamespacedName, service *protoStorage.Service) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	stored := &protoStorage.Service{}
+	copyInto(stored, service)
+	if store.services[namespacedName.Namespace] == nil {
+		store.services[namespacedName.Namespace] = make(map[string]*protoStorage.Service)
+	}
+	store.services[namespacedName.Namespace][namespacedName.Name] = stored
+	store.namespaces[namespacedName.Namespace] = true
+
+	return nil
+}
+
+func (store *MemoryStore) GetService(_ context.Context, name *protoStorage.NamespacedName, service *protoStorage.Service) error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	stored, ok := store.service(name)
+	if !ok {
+		return ErrorNotFound
+	}
+
+	copyInto(service, stored)
+	return nil
+}
+
+func (store *MemoryStore) GetServicesInNamespace(_ context.Context, namespace string) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.servicesInNamespace(namespace), nil
+}
+
+func (store *MemoryStore) servicesInNamespace(namespace string) []string {
+	names := make([]string, 0)
+	for name := range store.services[namespace] {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func (store *MemoryStore) DeleteService(_ context.Context, namespacedName *protoStorage.NamespacedName) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.services[namespacedName.Namespace], namespacedName.Name)
+	store.deleteNamespaceIfEmpty(namespacedName.Namespace)
+	return nil
+}
+
+func (store *MemoryStore) SetEndpoints(_ context.Context, endpointType EndpointType, name *protoStorage.NamespacedName, endpoints *protoCommon.EndpointList) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	member := dbNamespaceEndpointsMember(endpointType, name.Name)
+	if len(endpoints.Endpoints) == 0 {
+		delete(store.endpoints[name.Namespace], member)
+		store.deleteNamespaceIfEmpty(name.Namespace)
+		return nil
+	}
+
+	stored := &protoCommon.EndpointList{}
+	copyInto(stored, endpoints)
+	if store.endpoints[name.Namespace] == nil {
+		store.endpoints[name.Namespace] = make(map[string]*protoCommon.EndpointList)
+	}
+	store.endpoints[name.Namespace][member] = stored
+	store.namespaces[name.Namespace] = true
+	return nil
+}
+
+func (store *MemoryStore) GetEndpoints(_ context.Context, endpointType EndpointType, name *protoStorage.NamespacedName) (*protoCommon.EndpointList, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	el := &protoCommon.EndpointList{Endpoints: []*protoCommon.Endpoint{}}
+	if stored, ok := store.endpoints[name.Namespace][dbNamespaceEndpointsMember(endpointType, name.Name)]; ok {
+		copyInto(el, stored)
+	}
+
+	return el, nil
+}
+
+func (store *MemoryStore) GetNamespaces(_ context.Context) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	namespaces := make([]string, 0, len(store.namespaces))
+	for namespace := range store.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+func (store *MemoryStore) ExistsNamespace(_ context.Context, name string) (bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.namespaces[name], nil
+}
+
+// deleteNamespaceIfEmpty removes a namespace without routes, services and endpoint lists like the redis store
+func (store *MemoryStore) deleteNamespaceIfEmpty(namespace string) {
+	if len(store.routesInNamespace(namespace)) == 0 && len(store.servicesInNamespace(namespace)) == 0 && len(store.endpoints[namespace]) == 0 {
+		delete(store.namespaces, namespace)
+	}
+}
+
+func (store *MemoryStore) Ping(_ context.Context) error {
+	return nil
+}
+
+func (store *MemoryStore) GetDomainStats(_ context.Context) (*metrics.DomainStats, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	stats := &metrics.DomainStats{
+		Namespaces: int64(len(store.namespaces)),
+		Revisions:  int64(len(store.routes)),
+	}
+	for _, routes := range store.latestRevisions {
+		stats.Routes += int64(len(routes))
+	}
+	for _, services := range store.services {
+		stats.Services += int64(len(services))
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang/protobuf/proto"
+	protoCommon "github.com/kulycloud/protocol/common"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/config"
+	"strings"
+	"sync/atomic"
+)
+
+// Number of keys requested per SCAN call during migrations
+const migrationScanCount = 100
+
+// needsMigration reports whether a stored value is not in the configured storage encoding
+func needsMigration(str string) bool {
+	return isLegacyFormat(str) != (config.GlobalConfig.StorageEncoding == "json")
+}
+
+func messageConverter(newMessage func() proto.Message) func(string) (string, error) {
+	return func(str string) (string, error) {
+		message := newMessage()
+		err := decodeMessage(str, message)
+		if err != nil {
+			return "", err
+		}
+		return encodeMessage(message)
+	}
+}
+
+func convertDbRoute(str string) (string, error) {
+	route := &dbRoute{}
+	err := decodeDbRoute(str, route)
+	if err != nil {
+		return "", err
+	}
+	return encodeDbRoute(route)
+}
+
+// scanKeys calls fn for all keys matching pattern, on every master in cluster mode
+func (connector *Connector) scanKeys(ctx context.Context, pattern string, fn func(key string) error) error {
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, pattern, migrationScanCount).Iterator()
+		for iter.Next(ctx) {
+			err := fn(iter.Val())
+			if err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+
+	if cluster, ok := connector.redisClient.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scan(ctx, client)
+		})
+	}
+	return scan(ctx, connector.redisClient)
+}
+
+// migrateValue rewrites a string key in the configured storage encoding, returns whether it was rewritten
+func (connector *Connector) migrateValue(ctx context.Context, key string, convert func(string) (string, error)) (bool, error) {
+	migrated := false
+	err := connector.watch(ctx, func(tx *redis.Tx) error {
+		str, err := tx.Get(ctx, key).Result()
+		if err != nil {
+			if err == redis.Nil {
+				return nil
+			}
+			return err
+		}
+		if !needsMigration(str) {
+			return nil
+		}
+
+		str, err = convert(str)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, key, str, 0)
+			return nil
+		})
+		migrated = err == nil
+		return err
+	}, key)
+	return migrated, err
+}
+
+// migrateList rewrites all elements of a list key in the configured storage encoding, returns whether it was rewritten
+func (connector *Connector) migrateList(ctx context.Context, key string, convert func(string) (string, error)) (bool, error) {
+	migrated := false
+	err := connector.watch(ctx, func(tx *redis.Tx) error {
+		values, err := tx.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+
+		elements := make([]interface{}, 0, len(values))
+		changed := false
+		for _, str := range values {
+			if needsMigration(str) {
+				changed = true
+				str, err = convert(str)
+				if err != nil {
+					return err
+				}
+			}
+			elements = append(elements, str)
+		}
+		if !changed {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Del(ctx, key)
+			p.RPush(ctx, key, elements...)
+			return nil
+		})
+		migrated = err == nil
+		return err
+	}, key)
+	return migrated, err
+}
+
+// namespacePattern matches all keys starting with prefix followed by a name in namespace,
+// or in any namespace if namespace is empty
+func namespacePattern(namespace string, prefix string) string {
+	if namespace == "" {
+		return dbKeyPattern(prefix)
+	}
+	return dbNamespaceKey(namespace, prefix+globEscaper.Replace(namespace)+":*")
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// MigrateStorageEncoding rewrites all stored routes, route steps, services and endpoint lists of namespace
+// not yet stored in the configured storage encoding, an empty namespace migrates all namespaces.
+// It is safe to run while the storage is in use. Returns the number of rewritten keys.
+func (connector *Connector) MigrateStorageEncoding(ctx context.Context, namespace string) (int64, error) {
+	ctx, span := startSpan(ctx, "MigrateStorageEncoding")
+	defer span.End()
+
+	var count int64
+	countMigrated := func(migrated bool, err error) error {
+		if migrated {
+			atomic.AddInt64(&count, 1)
+		}
+		return err
+	}
+
+	err := connector.scanKeys(ctx, namespacePattern(namespace, "routes/"), func(key string) error {
+		name := strings.TrimPrefix(untaggedKey(key), "routes/")
+		switch {
+		case strings.HasSuffix(name, "/steps"):
+			return countMigrated(connector.migrateList(ctx, key, messageConverter(func() proto.Message { return &protoStorage.RouteStep{} })))
+		case strings.Contains(name, "/"):
+			// References hashes are internal indexes not affected by the storage encoding
+			return nil
+		case strings.Contains(name, "@"):
+			return countMigrated(connector.migrateValue(ctx, key, convertDbRoute))
+		default:
+			// Set of the routes in a namespace
+			return nil
+		}
+	})
+	if err != nil {
+		return count, err
+	}
+
+	err = connector.scanKeys(ctx, namespacePattern(namespace, "services/"), func(key string) error {
+		// Sets of the services in a namespace have no ":" in their name
+		if !strings.Contains(untaggedKey(key), ":") {
+			return nil
+		}
+		return countMigrated(connector.migrateValue(ctx, key, messageConverter(func() proto.Message { return &protoStorage.Service{} })))
+	})
+	if err != nil {
+		return count, err
+	}
+
+	err = connector.scanKeys(ctx, namespacePattern(namespace, "endpoints/*/"), func(key string) error {
+		return countMigrated(connector.migrateValue(ctx, key, messageConverter(func() proto.Message { return &protoCommon.EndpointList{} })))
+	})
+	return count, err
+}
@@ -2,63 +2,358 @@ package database
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"github.com/go-redis/redis/v8"
+	protoCommon "github.com/kulycloud/protocol/common"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/metrics"
+	"strings"
+	"time"
 )
 
+var ErrNamespaceExists = errors.New("namespace already exists")
+var ErrNamespaceNotEmpty = errors.New("namespace is not empty")
+
 const dbNamespacesName = "namespaces"
 
+func dbNamespaceName(name string) string {
+	return dbNamespaceKey(name, "namespaces/"+name)
+}
+
+// Namespace holds the metadata of an explicitly created namespace.
+// Namespaces created implicitly by storing objects have no metadata.
+type Namespace struct {
+	Name        string            `json:"-"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+}
+
 func (connector *Connector) GetNamespaces(ctx context.Context) ([]string, error) {
-	return connector.redisClient.SMembers(ctx, dbNamespacesName).Result()
+	ctx, span := startSpan(ctx, "GetNamespaces")
+	defer span.End()
+
+	return connector.redisClient.SMembers(ctx, dbGlobalKey(dbNamespacesName)).Result()
 }
 
 func (connector *Connector) AddNamespaceIfNotExists(ctx context.Context, name string) error {
-	return connector.redisClient.SAdd(ctx, dbNamespacesName, name).Err()
+	ctx, span := startSpan(ctx, "AddNamespaceIfNotExists")
+	defer span.End()
+
+	return connector.redisClient.SAdd(ctx, dbGlobalKey(dbNamespacesName), name).Err()
 }
 
 func (connector *Connector) AddNamespaceIfNotExistsTx(ctx context.Context, tx redis.Pipeliner, name string) {
-	tx.SAdd(ctx, dbNamespacesName, name)
+	ctx, span := startSpan(ctx, "AddNamespaceIfNotExistsTx")
+	defer span.End()
+
+	tx.SAdd(ctx, dbGlobalKey(dbNamespacesName), name)
+}
+
+// CreateNamespace explicitly creates a namespace with metadata, the creation time is set automatically
+func (connector *Connector) CreateNamespace(ctx context.Context, namespace *Namespace) error {
+	ctx, span := startSpan(ctx, "CreateNamespace")
+	defer span.End()
+
+	namespace.CreatedAt = time.Now()
+	str, err := json.Marshal(namespace)
+	if err != nil {
+		return err
+	}
+
+	key := dbNamespaceName(namespace.Name)
+	// The membership is added first, an interrupted creation in cluster mode leaves an implicit namespace behind
+	return connector.transaction(ctx, txPart{
+		keys: []string{dbGlobalKey(dbNamespacesName)},
+		check: func(*redis.Tx) (func(p redis.Pipeliner), error) {
+			return func(p redis.Pipeliner) {
+				connector.AddNamespaceIfNotExistsTx(ctx, p, namespace.Name)
+			}, nil
+		},
+	}, txPart{
+		keys: []string{key},
+		check: func(tx *redis.Tx) (func(p redis.Pipeliner), error) {
+			exists, err := tx.Exists(ctx, key).Result()
+			if err != nil {
+				return nil, err
+			}
+			if exists > 0 {
+				return nil, ErrNamespaceExists
+			}
+			return func(p redis.Pipeliner) {
+				p.Set(ctx, key, str, 0)
+			}, nil
+		},
+	})
+}
+
+func (connector *Connector) GetNamespace(ctx context.Context, name string) (*Namespace, error) {
+	ctx, span := startSpan(ctx, "GetNamespace")
+	defer span.End()
+
+	exists, err := connector.ExistsNamespace(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrorNotFound
+	}
+
+	namespace := &Namespace{}
+	str, err := connector.redisClient.Get(ctx, dbNamespaceName(name)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal([]byte(str), namespace)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	namespace.Name = name
+	return namespace, nil
+}
+
+// DeleteNamespace removes a namespace. Non-empty namespaces are only removed with cascade,
+// which deletes all routes, services and endpoint lists contained in it.
+// The route revision counters of the namespace are kept on purpose, so uids are never reused if it is recreated.
+func (connector *Connector) DeleteNamespace(ctx context.Context, name string, cascade bool) error {
+	ctx, span := startSpan(ctx, "DeleteNamespace")
+	defer span.End()
+
+	exists, err := connector.ExistsNamespace(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrorNotFound
+	}
+
+	if cascade {
+		err = connector.deleteNamespaceContents(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return connector.removeNamespace(ctx, name, true)
+}
+
+func (connector *Connector) deleteNamespaceContents(ctx context.Context, name string) error {
+	routes, err := connector.GetRoutesInNamespace(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, uid := range routes {
+		namespacedName, err := ParseUid(uid)
+		if err != nil {
+			continue
+		}
+		err = connector.DeleteRoute(ctx, namespacedName)
+		if err != nil && err != ErrorNotFound && err != redis.Nil {
+			return err
+		}
+	}
+
+	services, err := connector.GetServicesInNamespace(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		err = connector.DeleteService(ctx, &protoStorage.NamespacedName{Namespace: name, Name: service})
+		if err != nil {
+			return err
+		}
+	}
+
+	endpoints, err := connector.redisClient.SMembers(ctx, dbNamespaceEndpointsName(name)).Result()
+	if err != nil {
+		return err
+	}
+	for _, member := range endpoints {
+		parts := strings.SplitN(member, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		err = connector.SetEndpoints(ctx, EndpointType(parts[0]), &protoStorage.NamespacedName{Namespace: name, Name: parts[1]}, &protoCommon.EndpointList{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (connector *Connector) DeleteNamespace(ctx context.Context, name string) error {
-	return connector.redisClient.SRem(ctx, dbNamespacesName, name).Err()
+// removeNamespace deletes the metadata, quota and membership of an empty namespace, objects stored concurrently
+// abort the transaction. With requireEmpty a non-empty namespace is an error, otherwise non-empty and explicit
+// namespaces are kept silently.
+func (connector *Connector) removeNamespace(ctx context.Context, name string, requireEmpty bool) error {
+	metadataKey := dbNamespaceName(name)
+	quotaKey := dbNamespaceQuotaName(name)
+	removed := false
+
+	// The membership is removed last, an interrupted removal in cluster mode leaves an implicit namespace behind
+	return connector.transaction(ctx, txPart{
+		keys: []string{dbNamespaceServicesName(name), dbNamespaceRoutesName(name), dbNamespaceEndpointsName(name), metadataKey, quotaKey},
+		check: func(tx *redis.Tx) (func(p redis.Pipeliner), error) {
+			removed = false
+			size, err := namespaceSize(ctx, tx, name)
+			if err != nil {
+				return nil, err
+			}
+			if size != 0 {
+				if requireEmpty {
+					return nil, ErrNamespaceNotEmpty
+				}
+				return nil, nil
+			}
+			if !requireEmpty {
+				explicit, err := tx.Exists(ctx, metadataKey, quotaKey).Result()
+				if err != nil || explicit > 0 {
+					return nil, err
+				}
+			}
+
+			removed = true
+			return func(p redis.Pipeliner) {
+				p.Del(ctx, metadataKey, quotaKey)
+			}, nil
+		},
+	}, txPart{
+		keys: []string{dbGlobalKey(dbNamespacesName)},
+		check: func(*redis.Tx) (func(p redis.Pipeliner), error) {
+			if !removed {
+				return nil, nil
+			}
+			return func(p redis.Pipeliner) {
+				p.SRem(ctx, dbGlobalKey(dbNamespacesName), name)
+			}, nil
+		},
+	})
 }
 
 func (connector *Connector) ExistsNamespace(ctx context.Context, name string) (bool, error) {
-	return connector.redisClient.SIsMember(ctx, dbNamespacesName, name).Result()
+	ctx, span := startSpan(ctx, "ExistsNamespace")
+	defer span.End()
+
+	return connector.redisClient.SIsMember(ctx, dbGlobalKey(dbNamespacesName), name).Result()
 }
 
+// GetNamespaceSize returns the number of routes, services and endpoint lists in a namespace
 func (connector *Connector) GetNamespaceSize(ctx context.Context, name string) (int64, error) {
-	var size int64 = 0
-	services, err := connector.redisClient.SCard(ctx, dbNamespaceServicesName(name)).Result()
+	ctx, span := startSpan(ctx, "GetNamespaceSize")
+	defer span.End()
+
+	return namespaceSize(ctx, connector.redisClient, name)
+}
+
+func namespaceSize(ctx context.Context, client redis.Cmdable, name string) (int64, error) {
+	p := client.Pipeline()
+	services := p.SCard(ctx, dbNamespaceServicesName(name))
+	routes := p.SCard(ctx, dbNamespaceRoutesName(name))
+	endpoints := p.SCard(ctx, dbNamespaceEndpointsName(name))
+	_, err := p.Exec(ctx)
 	if err != nil {
-		size += services
-	} else {
-		if err != redis.Nil {
-			return 0, err
-		}
+		return 0, err
+	}
+
+	return services.Val() + routes.Val() + endpoints.Val(), nil
+}
+
+type NamespaceStats struct {
+	Routes        int64
+	Services      int64
+	EndpointLists int64
+	// Stored revisions of all routes including the latest ones
+	Revisions int64
+}
+
+func (connector *Connector) GetNamespaceStats(ctx context.Context, name string) (*NamespaceStats, error) {
+	ctx, span := startSpan(ctx, "GetNamespaceStats")
+	defer span.End()
+
+	p := connector.redisClient.Pipeline()
+	services := p.SCard(ctx, dbNamespaceServicesName(name))
+	endpoints := p.SCard(ctx, dbNamespaceEndpointsName(name))
+	routes := p.SMembers(ctx, dbNamespaceRoutesName(name))
+	_, err := p.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &NamespaceStats{
+		Routes:        int64(len(routes.Val())),
+		Services:      services.Val(),
+		EndpointLists: endpoints.Val(),
+	}
+
+	if len(routes.Val()) == 0 {
+		return stats, nil
 	}
 
-	routes, err := connector.redisClient.SCard(ctx, dbNamespaceRoutesName(name)).Result()
+	p = connector.redisClient.Pipeline()
+	names := make([]*protoStorage.NamespacedName, 0, len(routes.Val()))
+	revisions := make([]*redis.IntCmd, 0, len(routes.Val()))
+	for _, uid := range routes.Val() {
+		namespacedName, err := ParseUid(uid)
+		if err != nil {
+			continue
+		}
+		names = append(names, namespacedName)
+		revisions = append(revisions, p.ZCard(ctx, dbRouteHistoryName(namespacedName)))
+	}
+	_, err = p.Exec(ctx)
 	if err != nil {
-		size += routes
-	} else {
-		if err != redis.Nil {
-			return 0, err
+		return nil, err
+	}
+
+	for i, count := range revisions {
+		if count.Val() > 0 {
+			stats.Revisions += count.Val()
+			continue
+		}
+
+		// Routes stored before the history existed
+		legacy, err := legacyRouteRevisions(ctx, connector.redisClient, names[i])
+		if err != nil && err != ErrorNotFound {
+			return nil, err
 		}
+		stats.Revisions += int64(len(legacy))
 	}
 
-	return size, nil
+	return stats, nil
 }
 
-func (connector *Connector) DeleteNamespaceIfEmpty(ctx context.Context, name string) error {
-	size, err := connector.GetNamespaceSize(ctx, name)
+// GetDomainStats sums up the stats of all namespaces
+func (connector *Connector) GetDomainStats(ctx context.Context) (*metrics.DomainStats, error) {
+	ctx, span := startSpan(ctx, "GetDomainStats")
+	defer span.End()
+
+	namespaces, err := connector.GetNamespaces(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if size != 0 {
-		return nil
+	stats := &metrics.DomainStats{Namespaces: int64(len(namespaces))}
+	for _, namespace := range namespaces {
+		namespaceStats, err := connector.GetNamespaceStats(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		stats.Routes += namespaceStats.Routes
+		stats.Services += namespaceStats.Services
+		stats.Revisions += namespaceStats.Revisions
 	}
 
-	return connector.DeleteNamespace(ctx, name)
+	return stats, nil
+}
+
+// DeleteNamespaceIfEmpty removes implicitly created namespaces without quota once their last object is deleted
+func (connector *Connector) DeleteNamespaceIfEmpty(ctx context.Context, name string) error {
+	ctx, span := startSpan(ctx, "DeleteNamespaceIfEmpty")
+	defer span.End()
+
+	return connector.removeNamespace(ctx, name, false)
 }
@@ -0,0 +1,261 @@
+package database
+
+import (
+	"context"
+	protoCommon "github.com/kulycloud/protocol/common"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"testing"
+)
+
+func TestCreateNamespace(t *testing.T) {
+	connector := newTestConnector(t)
+	ctx := context.Background()
+	name := newTestNamespace(t, connector)
+
+	err := connector.CreateNamespace(ctx, &Namespace{Name: name, Labels: map[string]string{"team": "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = connector.CreateNamespace(ctx, &Namespace{Name: name})
+	if err != ErrNamespaceExists {
+		t.Errorf("second CreateNamespace returned %v, want %v", err, ErrNamespaceExists)
+	}
+
+	namespace, err := connector.GetNamespace(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if namespace.Labels["team"] != "a" {
+		t.Errorf("labels of the first creation were not kept: %v", namespace.Labels)
+	}
+}
+
+func assertNamespaceExists(t *testing.T, connector *Connector, name string, want bool) {
+	t.Helper()
+
+	exists, err := connector.ExistsNamespace(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists != want {
+		t.Errorf("namespace %s exists: %v, want %v", name, exists, want)
+	}
+}
+
+func TestDeleteNamespaceIfEmpty(t *testing.T) {
+	connector := newTestConnector(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// setup stores objects in the namespace and returns whether it has to be kept
+		setup func(t *testing.T, namespace string) bool
+	}{
+		{
+			name: "implicit",
+			setup: func(t *testing.T, namespace string) bool {
+				return false
+			},
+		},
+		{
+			name: "explicit",
+			setup: func(t *testing.T, namespace string) bool {
+				err := connector.CreateNamespace(ctx, &Namespace{Name: namespace})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return true
+			},
+		},
+		{
+			name: "quota",
+			setup: func(t *testing.T, namespace string) bool {
+				err := connector.SetNamespaceQuota(ctx, namespace, &Quota{MaxRoutes: 1})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return true
+			},
+		},
+		{
+			name: "service",
+			setup: func(t *testing.T, namespace string) bool {
+				newTestService(t, connector, namespace, "other")
+				return true
+			},
+		},
+		{
+			name: "route",
+			setup: func(t *testing.T, namespace string) bool {
+				service := newTestService(t, connector, namespace, "other")
+				_, err := connector.SetRoute(ctx, &protoStorage.NamespacedName{Namespace: namespace, Name: "route"}, newTestRoute(namespace+".example.com", service))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return true
+			},
+		},
+		{
+			name: "endpoints",
+			setup: func(t *testing.T, namespace string) bool {
+				err := connector.SetEndpoints(ctx, ServiceLBEndpoints, &protoStorage.NamespacedName{Namespace: namespace, Name: "other"},
+					&protoCommon.EndpointList{Endpoints: []*protoCommon.Endpoint{{Host: "10.0.0.1", Port: 8080}}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return true
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespace := newTestNamespace(t, connector)
+			service := newTestService(t, connector, namespace, "backend")
+			kept := tt.setup(t, namespace)
+
+			// Deleting the last service of an implicit namespace removes it
+			err := connector.DeleteService(ctx, service)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertNamespaceExists(t, connector, namespace, kept)
+
+			err = connector.DeleteNamespaceIfEmpty(ctx, namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertNamespaceExists(t, connector, namespace, kept)
+		})
+	}
+}
+
+func TestDeleteNamespaceIfEmptyAfterEndpoints(t *testing.T) {
+	connector := newTestConnector(t)
+	ctx := context.Background()
+	namespace := newTestNamespace(t, connector)
+	service := newTestService(t, connector, namespace, "backend")
+
+	err := connector.SetEndpoints(ctx, ServiceLBEndpoints, service, &protoCommon.EndpointList{Endpoints: []*protoCommon.Endpoint{{Host: "10.0.0.1", Port: 8080}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = connector.DeleteNamespace(ctx, namespace, false)
+	if err != ErrNamespaceNotEmpty {
+		t.Errorf("DeleteNamespace returned %v, want %v", err, ErrNamespaceNotEmpty)
+	}
+	assertNamespaceExists(t, connector, namespace, true)
+
+	err = connector.DeleteService(ctx, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The endpoint list keeps the namespace until it is removed as well
+	assertNamespaceExists(t, connector, namespace, true)
+
+	err = connector.SetEndpoints(ctx, ServiceLBEndpoints, service, &protoCommon.EndpointList{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNamespaceExists(t, connector, namespace, false)
+}
+
+func TestDeleteNamespaceCascade(t *testing.T) {
+	connector := newTestConnector(t)
+	ctx := context.Background()
+	namespace := newTestNamespace(t, connector)
+	service := newTestService(t, connector, namespace, "backend")
+	name := &protoStorage.NamespacedName{Namespace: namespace, Name: "route"}
+	_, err := connector.SetRoute(ctx, name, newTestRoute(namespace+".example.com", service))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = connector.SetEndpoints(ctx, ServiceLBEndpoints, service, &protoCommon.EndpointList{Endpoints: []*protoCommon.Endpoint{{Host: "10.0.0.1", Port: 8080}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = connector.DeleteNamespace(ctx, namespace, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNamespaceExists(t, connector, namespace, false)
+	size, err := connector.GetNamespaceSize(ctx, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 0 {
+		t.Errorf("namespace has %v objects left", size)
+	}
+
+	// Revision counters are kept so a recreated route does not reuse uids
+	uid, err := connector.SetRoute(ctx, name, newTestRoute(namespace+".example.com", newTestService(t, connector, namespace, "backend")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision := uidRevision(t, uid); revision != 2 {
+		t.Errorf("recreated route has revision %v, want 2", revision)
+	}
+}
+
+func TestMemoryStoreNamespaceWithEndpoints(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	service := &protoStorage.NamespacedName{Namespace: "test", Name: "backend"}
+	endpoints := &protoCommon.EndpointList{Endpoints: []*protoCommon.Endpoint{{Host: "10.0.0.1", Port: 8080}}}
+
+	assertExists := func(want bool) {
+		t.Helper()
+
+		exists, err := store.ExistsNamespace(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != want {
+			t.Errorf("namespace exists: %v, want %v", exists, want)
+		}
+	}
+
+	// Storing endpoints registers the namespace like in redis
+	err := store.SetEndpoints(ctx, ServiceLBEndpoints, service, endpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExists(true)
+
+	err = store.SetService(ctx, service, &protoStorage.Service{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.DeleteService(ctx, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The endpoint list keeps the namespace until it is removed as well
+	assertExists(true)
+
+	err = store.SetEndpoints(ctx, ServiceLBEndpoints, service, &protoCommon.EndpointList{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExists(false)
+}
+
+func TestSetEndpointsRegistersNamespace(t *testing.T) {
+	connector := newTestConnector(t)
+	ctx := context.Background()
+	namespace := newTestNamespace(t, connector)
+	service := &protoStorage.NamespacedName{Namespace: namespace, Name: "backend"}
+
+	err := connector.SetEndpoints(ctx, ServiceLBEndpoints, service, &protoCommon.EndpointList{Endpoints: []*protoCommon.Endpoint{{Host: "10.0.0.1", Port: 8080}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNamespaceExists(t, connector, namespace, true)
+
+	err = connector.SetEndpoints(ctx, ServiceLBEndpoints, service, &protoCommon.EndpointList{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNamespaceExists(t, connector, namespace, false)
+}
@@ -0,0 +1,291 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang/protobuf/proto"
+	protoCommon "github.com/kulycloud/protocol/common"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"strconv"
+)
+
+// stepReference is stored per step in the references hash of a route revision so a step can be populated
+// without decoding the referenced steps first
+type stepReference struct {
+	Name      string `json:"name"`
+	Step      uint32 `json:"step"`
+	Endpoints string `json:"endpoints"`
+}
+
+// buildReferenceIndex returns the references hash of a route keyed by step id
+func buildReferenceIndex(route *protoStorage.Route) (map[string]interface{}, error) {
+	index := make(map[string]interface{}, len(route.Steps))
+	for i, step := range route.Steps {
+		references := make([]stepReference, 0, len(step.References))
+		for name, id := range step.References {
+			if int(id) >= len(route.Steps) || route.Steps[id].Service == nil {
+				continue
+			}
+			references = append(references, stepReference{
+				Name:      name,
+				Step:      id,
+				Endpoints: dbEndpointsName(ServiceLBEndpoints, route.Steps[id].Service),
+			})
+		}
+		str, err := json.Marshal(references)
+		if err != nil {
+			return nil, fmt.Errorf("could not serialize json: %w", err)
+		}
+		index[strconv.Itoa(i)] = string(str)
+	}
+	return index, nil
+}
+
+// populatedStepScript resolves a step and its references in a single round-trip.
+// It returns the step, whether the route has a references hash and name, step id, endpoints key and a
+// placeholder for the endpoints of each reference. Scripts may only access the keys passed as KEYS,
+// so the endpoint lists are read separately.
+var populatedStepScript = redis.NewScript(`
+local step = redis.call("LINDEX", KEYS[1], ARGV[1])
+if not step then
+	return false
+end
+local references = redis.call("HGET", KEYS[2], ARGV[1])
+if not references then
+	return {step, "0"}
+end
+local result = {step, "1"}
+for _, reference in ipairs(cjson.decode(references)) do
+	table.insert(result, reference.name)
+	table.insert(result, tostring(reference.step))
+	table.insert(result, reference.endpoints)
+	table.insert(result, false)
+end
+return result
+`)
+
+// indexedStep is a step together with its references hash entry, both are immutable for a revision
+type indexedStep struct {
+	step       *protoStorage.RouteStep
+	references []stepReference
+}
+
+// GetPopulatedRouteStep returns a step of a route with the service load balancer endpoints of all referenced steps
+func (connector *Connector) GetPopulatedRouteStep(ctx context.Context, uid string, id uint32) (*protoStorage.PopulatedRouteStep, error) {
+	ctx, span := startSpan(ctx, "GetPopulatedRouteStep")
+	defer span.End()
+
+	cacheKey := revisionCacheKey(uid, fmt.Sprintf("populated/%v", id))
+	if cached, ok := connector.cache.revisions.Get(cacheKey); ok {
+		if populatedStep, ok := connector.populateCachedStep(cached.(*indexedStep)); ok {
+			return populatedStep, nil
+		}
+	}
+
+	generation := connector.cache.currentGeneration()
+	strId := strconv.FormatUint(uint64(id), 10)
+	reply, err := populatedStepScript.Run(ctx, connector.redisClient, []string{dbRouteStepsName(uid), dbRouteReferencesName(uid)}, strId).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrorNotFound
+		}
+		return nil, err
+	}
+	result, ok := reply.([]interface{})
+	if !ok || len(result) < 2 {
+		return nil, fmt.Errorf("unexpected script reply %T", reply)
+	}
+
+	step := &protoStorage.RouteStep{}
+	err = decodeMessage(result[0].(string), step)
+	if err != nil {
+		return nil, fmt.Errorf("could not deserialize step: %w", err)
+	}
+
+	// Revisions written before the references hash existed are populated reference by reference
+	if result[1] != "1" {
+		return populateRouteStep(ctx, connector, uid, step)
+	}
+
+	err = connector.getScriptEndpoints(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := &indexedStep{step: proto.Clone(step).(*protoStorage.RouteStep)}
+	populatedStep := newPopulatedRouteStep(step)
+	for i := 2; i+3 < len(result); i += 4 {
+		stepId, err := strconv.ParseUint(result[i+1].(string), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		reference := stepReference{Name: result[i].(string), Step: uint32(stepId), Endpoints: result[i+2].(string)}
+		el := &protoCommon.EndpointList{Endpoints: []*protoCommon.Endpoint{}}
+		if str, ok := result[i+3].(string); ok {
+			el, err = parseEndpoints(str)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		connector.cache.addMutable(connector.cache.pointers, reference.Endpoints, proto.Clone(el), generation)
+		indexed.references = append(indexed.references, reference)
+		populatedStep.References[reference.Name] = &protoStorage.PopulatedRouteStepReference{
+			Step:      reference.Step,
+			Endpoints: el.Endpoints,
+		}
+	}
+
+	connector.cache.revisions.Add(cacheKey, indexed, 0)
+	return populatedStep, nil
+}
+
+// getScriptEndpoints fills in the endpoints of all references of a script result in one pipeline
+func (connector *Connector) getScriptEndpoints(ctx context.Context, result []interface{}) error {
+	if len(result) < 6 {
+		return nil
+	}
+
+	p := connector.redisClient.Pipeline()
+	endpoints := make(map[int]*redis.StringCmd)
+	for i := 2; i+3 < len(result); i += 4 {
+		endpoints[i+3] = p.Get(ctx, result[i+2].(string))
+	}
+	_, err := p.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	for i, cmd := range endpoints {
+		if cmd.Err() == nil {
+			result[i] = cmd.Val()
+		}
+	}
+	return nil
+}
+
+// populateCachedStep populates a cached step if the endpoints of all references are cached as well
+func (connector *Connector) populateCachedStep(indexed *indexedStep) (*protoStorage.PopulatedRouteStep, bool) {
+	populatedStep := newPopulatedRouteStep(proto.Clone(indexed.step).(*protoStorage.RouteStep))
+	for _, reference := range indexed.references {
+		cached, ok := connector.cache.pointers.Get(reference.Endpoints)
+		if !ok {
+			return nil, false
+		}
+		populatedStep.References[reference.Name] = &protoStorage.PopulatedRouteStepReference{
+			Step:      reference.Step,
+			Endpoints: proto.Clone(cached.(*protoCommon.EndpointList)).(*protoCommon.EndpointList).Endpoints,
+		}
+	}
+	return populatedStep, true
+}
+
+func newPopulatedRouteStep(step *protoStorage.RouteStep) *protoStorage.PopulatedRouteStep {
+	return &protoStorage.PopulatedRouteStep{
+		Service:    step.Service,
+		Config:     step.Config,
+		Name:       step.Name,
+		References: make(map[string]*protoStorage.PopulatedRouteStepReference),
+	}
+}
+
+// populateRouteStep looks up every reference of step separately
+func populateRouteStep(ctx context.Context, store Store, uid string, step *protoStorage.RouteStep) (*protoStorage.PopulatedRouteStep, error) {
+	populatedStep := newPopulatedRouteStep(step)
+	routeStep := &protoStorage.RouteStep{}
+
+	for name, stepId := range step.References {
+		err := store.GetRouteStep(ctx, uid, stepId, routeStep)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints, err := store.GetEndpoints(ctx, ServiceLBEndpoints, routeStep.Service)
+		if err != nil {
+			return nil, err
+		}
+
+		populatedStep.References[name] = &protoStorage.PopulatedRouteStepReference{
+			Step:      stepId,
+			Endpoints: endpoints.Endpoints,
+		}
+	}
+
+	return populatedStep, nil
+}
+
+// PopulatedRoute is a route revision with the endpoints of all referenced steps resolved
+type PopulatedRoute struct {
+	Uid   string
+	Host  string
+	Steps []*protoStorage.PopulatedRouteStep
+}
+
+// populateRoute resolves all steps of a route, endpoint lists are looked up once per service
+func populateRoute(ctx context.Context, store Store, uid string) (*PopulatedRoute, error) {
+	route := &protoStorage.Route{}
+	err := store.GetRoute(ctx, uid, route)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[string][]*protoCommon.Endpoint)
+	populatedRoute := &PopulatedRoute{
+		Uid:   uid,
+		Host:  route.Host,
+		Steps: make([]*protoStorage.PopulatedRouteStep, 0, len(route.Steps)),
+	}
+	for i, step := range route.Steps {
+		populatedStep := newPopulatedRouteStep(step)
+		for name, stepId := range step.References {
+			if int(stepId) >= len(route.Steps) || route.Steps[stepId].Service == nil {
+				return nil, &RouteValidationError{Problems: []*RouteProblem{{Step: i, Reference: name, Description: "references no step with a service"}}}
+			}
+			service := route.Steps[stepId].Service
+			key := dbEndpointsName(ServiceLBEndpoints, service)
+			if _, ok := endpoints[key]; !ok {
+				el, err := store.GetEndpoints(ctx, ServiceLBEndpoints, service)
+				if err != nil {
+					return nil, err
+				}
+				endpoints[key] = el.Endpoints
+			}
+
+			populatedStep.References[name] = &protoStorage.PopulatedRouteStepReference{
+				Step:      stepId,
+				Endpoints: endpoints[key],
+			}
+		}
+		populatedRoute.Steps = append(populatedRoute.Steps, populatedStep)
+	}
+
+	return populatedRoute, nil
+}
+
+// GetPopulatedRoute returns all steps of a route revision with their references resolved.
+// The result only changes when endpoints change, so it can be cached per uid.
+func (connector *Connector) GetPopulatedRoute(ctx context.Context, uid string) (*PopulatedRoute, error) {
+	ctx, span := startSpan(ctx, "GetPopulatedRoute")
+	defer span.End()
+
+	return populateRoute(ctx, connector, uid)
+}
+
+// populateRouteByHost resolves the route serving the root path of host
+func populateRouteByHost(ctx context.Context, store Store, host string) (*PopulatedRoute, error) {
+	uid, err := store.GetRouteUidByHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetPopulatedRoute(ctx, uid)
+}
+
+// GetPopulatedRouteByHost returns the populated route serving the root path of host
+func (connector *Connector) GetPopulatedRouteByHost(ctx context.Context, host string) (*PopulatedRoute, error) {
+	ctx, span := startSpan(ctx, "GetPopulatedRouteByHost")
+	defer span.End()
+
+	return populateRouteByHost(ctx, connector, host)
+}
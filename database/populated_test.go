@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	protoCommon "github.com/kulycloud/protocol/common"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"testing"
+)
+
+func TestGetPopulatedRouteByHost(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	frontend := &protoStorage.NamespacedName{Namespace: "test", Name: "frontend"}
+	backend := &protoStorage.NamespacedName{Namespace: "test", Name: "backend"}
+	for _, service := range []*protoStorage.NamespacedName{frontend, backend} {
+		err := store.SetService(ctx, service, &protoStorage.Service{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := store.SetEndpoints(ctx, ServiceLBEndpoints, backend, &protoCommon.EndpointList{Endpoints: []*protoCommon.Endpoint{{Host: "10.0.0.1", Port: 8080}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uid, err := store.SetRoute(ctx, &protoStorage.NamespacedName{Namespace: "test", Name: "route"}, &protoStorage.Route{
+		Host: "example.com",
+		Steps: []*protoStorage.RouteStep{
+			{Service: frontend, References: map[string]uint32{"next": 1}},
+			{Service: backend},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route, err := store.GetPopulatedRouteByHost(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route.Uid != uid || len(route.Steps) != 2 {
+		t.Fatalf("unexpected route %+v", route)
+	}
+	endpoints := route.Steps[0].References["next"].Endpoints
+	if len(endpoints) != 1 || endpoints[0].Host != "10.0.0.1" {
+		t.Errorf("unexpected endpoints %v", endpoints)
+	}
+
+	_, err = store.GetPopulatedRouteByHost(ctx, "other.com")
+	if err != ErrorNotFound {
+		t.Errorf("unknown host returned %v, want %v", err, ErrorNotFound)
+	}
+
+	// Routes stored before validation existed may reference steps without a service
+	store.routes[uid].Steps[1].Service = nil
+	_, err = store.GetPopulatedRouteByHost(ctx, "example.com")
+	if !errors.Is(err, ErrInvalidRoute) {
+		t.Errorf("broken reference returned %v, want %v", err, ErrInvalidRoute)
+	}
+}
+
+// BenchmarkGetPopulatedRouteStep compares the script resolving a step and one pipeline reading the endpoint lists
+// with looking up every reference separately
+func BenchmarkGetPopulatedRouteStep(b *testing.B) {
+	connector := newTestConnector(b)
+	ctx := context.Background()
+	namespace := newTestNamespace(b, connector)
+
+	const references = 8
+	route := &protoStorage.Route{
+		Host:  namespace + ".example.com",
+		Steps: []*protoStorage.RouteStep{{Service: newTestService(b, connector, namespace, "frontend"), References: map[string]uint32{}}},
+	}
+	for i := 1; i <= references; i++ {
+		service := newTestService(b, connector, namespace, fmt.Sprintf("backend-%v", i))
+		err := connector.SetEndpoints(ctx, ServiceLBEndpoints, service, &protoCommon.EndpointList{Endpoints: []*protoCommon.Endpoint{{Host: "10.0.0.1", Port: 8080}}})
+		if err != nil {
+			b.Fatal(err)
+		}
+		route.Steps[0].References[fmt.Sprintf("backend-%v", i)] = uint32(i)
+		route.Steps = append(route.Steps, &protoStorage.RouteStep{Service: service})
+	}
+
+	uid, err := connector.SetRoute(ctx, &protoStorage.NamespacedName{Namespace: namespace, Name: "route"}, route)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("script", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := connector.GetPopulatedRouteStep(ctx, uid, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("per-reference", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			step := &protoStorage.RouteStep{}
+			err := connector.GetRouteStep(ctx, uid, 0, step)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, err = populateRouteStep(ctx, connector, uid, step)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
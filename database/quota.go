@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the resources of a namespace, zero values are unlimited
+type Quota struct {
+	MaxRoutes        int64 `json:"maxRoutes,omitempty"`
+	MaxServices      int64 `json:"maxServices,omitempty"`
+	MaxStepsPerRoute int64 `json:"maxStepsPerRoute,omitempty"`
+	// Revisions retained per route including the latest one
+	MaxRevisions int64 `json:"maxRevisions,omitempty"`
+	MaxEndpoints int64 `json:"maxEndpoints,omitempty"`
+}
+
+type QuotaExceededError struct {
+	Resource string
+	Limit    int64
+}
+
+func (err *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s: at most %v %s allowed", ErrQuotaExceeded, err.Limit, err.Resource)
+}
+
+func (err *QuotaExceededError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+type QuotaUsage struct {
+	Quota *Quota
+	Stats *NamespaceStats
+}
+
+func dbNamespaceQuotaName(namespace string) string {
+	return dbNamespaceKey(namespace, "namespaces/"+namespace+"/quota")
+}
+
+func checkLimit(resource string, limit int64, value int64) error {
+	if limit > 0 && value > limit {
+		return &QuotaExceededError{Resource: resource, Limit: limit}
+	}
+	return nil
+}
+
+func getQuota(ctx context.Context, client redis.Cmdable, namespace string) (*Quota, error) {
+	quota := &Quota{}
+	str, err := client.Get(ctx, dbNamespaceQuotaName(namespace)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return quota, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(str), quota)
+	if err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+// checkCountQuota checks if one more member fits into the set at key
+func checkCountQuota(ctx context.Context, client redis.Cmdable, key string, resource string, limit int64) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	count, err := client.SCard(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	return checkLimit(resource, limit, count+1)
+}
+
+func (connector *Connector) GetNamespaceQuota(ctx context.Context, namespace string) (*Quota, error) {
+	ctx, span := startSpan(ctx, "GetNamespaceQuota")
+	defer span.End()
+
+	return getQuota(ctx, connector.redisClient, namespace)
+}
+
+// SetNamespaceQuota replaces the quota of a namespace. Existing objects exceeding the quota are kept.
+func (connector *Connector) SetNamespaceQuota(ctx context.Context, namespace string, quota *Quota) error {
+	ctx, span := startSpan(ctx, "SetNamespaceQuota")
+	defer span.End()
+
+	str, err := json.Marshal(quota)
+	if err != nil {
+		return err
+	}
+
+	return connector.redisClient.Set(ctx, dbNamespaceQuotaName(namespace), str, 0).Err()
+}
+
+func (connector *Connector) GetQuotaUsage(ctx context.Context, namespace string) (*QuotaUsage, error) {
+	ctx, span := startSpan(ctx, "GetQuotaUsage")
+	defer span.End()
+
+	quota, err := connector.GetNamespaceQuota(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := connector.GetNamespaceStats(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuotaUsage{Quota: quota, Stats: stats}, nil
+}
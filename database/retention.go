@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/config"
+	"github.com/kulycloud/storage-redis/metrics"
+	"time"
+)
+
+// keptRevisions returns the number of revisions kept besides the latest one or -1 if all are kept
+func keptRevisions(quota *Quota) int {
+	kept := -1
+	if config.GlobalConfig.RouteRevisionsKept > 0 {
+		kept = int(config.GlobalConfig.RouteRevisionsKept)
+	}
+
+	// The quota counts the latest revision as well
+	if quota.MaxRevisions > 0 && (kept < 0 || int(quota.MaxRevisions)-1 < kept) {
+		kept = int(quota.MaxRevisions) - 1
+	}
+
+	return kept
+}
+
+func expiredRevisions(revisions []*RouteRevision, now time.Time, kept int) []*RouteRevision {
+	maxAge := time.Duration(config.GlobalConfig.RouteRevisionMaxAge) * time.Second
+
+	expired := make([]*RouteRevision, 0)
+	// The latest revision is never removed, revisions without creation time only expire by count
+	old := len(revisions) - 1
+	for i, revision := range revisions[:old] {
+		if kept >= 0 && i < old-kept {
+			expired = append(expired, revision)
+		} else if maxAge > 0 && !revision.CreatedAt.IsZero() && now.Sub(revision.CreatedAt) > maxAge {
+			expired = append(expired, revision)
+		}
+	}
+
+	return expired
+}
+
+// PruneRouteRevisions removes all revisions of a route that are outside of the configured retention policy
+// and returns the number of removed keys
+func (connector *Connector) PruneRouteRevisions(ctx context.Context, namespacedName *protoStorage.NamespacedName) (int64, error) {
+	ctx, span := startSpan(ctx, "PruneRouteRevisions")
+	defer span.End()
+
+	quota, err := connector.GetNamespaceQuota(ctx, namespacedName.Namespace)
+	if err != nil {
+		return 0, err
+	}
+
+	kept := keptRevisions(quota)
+	if kept < 0 && config.GlobalConfig.RouteRevisionMaxAge == 0 {
+		return 0, nil
+	}
+
+	revisions, err := connector.GetRouteRevisions(ctx, namespacedName)
+	if err != nil {
+		if err == ErrorNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	expired := expiredRevisions(revisions, time.Now(), kept)
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	keys := make([]string, 0, 3*len(expired))
+	members := make([]interface{}, 0, len(expired))
+	for _, revision := range expired {
+		keys = append(keys, dbRouteName(revision.Uid), dbRouteStepsName(revision.Uid), dbRouteReferencesName(revision.Uid))
+		members = append(members, revision.Revision)
+	}
+
+	tx := connector.redisClient.TxPipeline()
+	del := tx.Del(ctx, keys...)
+	tx.ZRem(ctx, dbRouteHistoryName(namespacedName), members...)
+	_, err = tx.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	metrics.ObserveReclaimedRevisionKeys(del.Val())
+
+	// Revisions are cached without ttl, all replicas have to drop the pruned ones
+	p := connector.redisClient.Pipeline()
+	for _, revision := range expired {
+		event := &Event{Kind: RouteEvent, Type: Pruned, NamespacedName: namespacedName, Uid: revision.Uid, Revision: revision.Revision}
+		connector.cache.invalidate(event)
+		publishEvent(ctx, p, event)
+	}
+	_, err = p.Exec(ctx)
+	if err != nil {
+		logger.Warnw("Could not publish pruned revisions, other replicas may serve them from their cache", "error", err)
+	}
+
+	return del.Val(), nil
+}
+
+// CollectRevisionGarbage applies the retention policy to all routes
+func (connector *Connector) CollectRevisionGarbage(ctx context.Context) (int64, error) {
+	ctx, span := startSpan(ctx, "CollectRevisionGarbage")
+	defer span.End()
+
+	namespaces, err := connector.GetNamespaces(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var reclaimed int64 = 0
+	for _, namespace := range namespaces {
+		uids, err := connector.GetRoutesInNamespace(ctx, namespace)
+		if err != nil {
+			return reclaimed, err
+		}
+
+		for _, uid := range uids {
+			namespacedName, err := ParseUid(uid)
+			if err != nil {
+				logger.Warnw("Skipping route with invalid uid", "uid", uid)
+				continue
+			}
+
+			count, err := connector.PruneRouteRevisions(ctx, namespacedName)
+			reclaimed += count
+			if err != nil {
+				return reclaimed, err
+			}
+		}
+	}
+
+	return reclaimed, nil
+}
+
+// RunRevisionGc periodically collects revision garbage until the context is cancelled
+func (connector *Connector) RunRevisionGc(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reclaimed, err := connector.CollectRevisionGarbage(ctx)
+			if err != nil {
+				logger.Errorw("Error collecting revision garbage", "error", err)
+			}
+			if reclaimed > 0 {
+				logger.Infow("Reclaimed revision keys", "count", reclaimed)
+			}
+		}
+	}
+}
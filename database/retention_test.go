@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/config"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// setRetentionPolicy configures the revision retention for the calling test and restores it afterwards
+func setRetentionPolicy(t *testing.T, kept uint32, maxAge uint32) {
+	t.Helper()
+
+	oldKept, oldMaxAge := config.GlobalConfig.RouteRevisionsKept, config.GlobalConfig.RouteRevisionMaxAge
+	t.Cleanup(func() {
+		config.GlobalConfig.RouteRevisionsKept, config.GlobalConfig.RouteRevisionMaxAge = oldKept, oldMaxAge
+	})
+	config.GlobalConfig.RouteRevisionsKept = kept
+	config.GlobalConfig.RouteRevisionMaxAge = maxAge
+}
+
+func TestExpiredRevisions(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		kept   uint32
+		maxAge uint32
+		quota  *Quota
+		// Ages of the revisions from oldest to latest
+		ages []time.Duration
+		want []uint64
+	}{
+		{
+			name: "keep all",
+			ages: []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour},
+			want: []uint64{},
+		},
+		{
+			name: "count",
+			kept: 2,
+			ages: []time.Duration{5 * time.Hour, 4 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour},
+			want: []uint64{1, 2},
+		},
+		{
+			name: "count larger than history",
+			kept: 10,
+			ages: []time.Duration{2 * time.Hour, time.Hour},
+			want: []uint64{},
+		},
+		{
+			name:   "age",
+			maxAge: 3600,
+			ages:   []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute, 10 * time.Minute},
+			want:   []uint64{1, 2},
+		},
+		{
+			name:   "count and age",
+			kept:   3,
+			maxAge: 3600,
+			ages:   []time.Duration{5 * time.Hour, 4 * time.Hour, 3 * time.Hour, 30 * time.Minute, 10 * time.Minute},
+			want:   []uint64{1, 2, 3},
+		},
+		{
+			name:   "latest is kept by age",
+			maxAge: 3600,
+			ages:   []time.Duration{3 * time.Hour, 2 * time.Hour},
+			want:   []uint64{1},
+		},
+		{
+			name:  "latest is kept by quota",
+			quota: &Quota{MaxRevisions: 1},
+			ages:  []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour},
+			want:  []uint64{1, 2},
+		},
+		{
+			name:   "unknown creation time",
+			maxAge: 3600,
+			ages:   []time.Duration{0, 0, 0},
+			want:   []uint64{},
+		},
+		{
+			name:  "quota overrides kept revisions",
+			kept:  5,
+			quota: &Quota{MaxRevisions: 3},
+			ages:  []time.Duration{5 * time.Hour, 4 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour},
+			want:  []uint64{1, 2},
+		},
+		{
+			name:  "larger quota keeps kept revisions",
+			kept:  1,
+			quota: &Quota{MaxRevisions: 10},
+			ages:  []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour},
+			want:  []uint64{1},
+		},
+		{
+			name:  "quota without kept revisions",
+			quota: &Quota{MaxRevisions: 2},
+			ages:  []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour},
+			want:  []uint64{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRetentionPolicy(t, tt.kept, tt.maxAge)
+			quota := tt.quota
+			if quota == nil {
+				quota = &Quota{}
+			}
+
+			revisions := make([]*RouteRevision, 0, len(tt.ages))
+			for i, age := range tt.ages {
+				revision := &RouteRevision{
+					Uid:      buildUid(&protoStorage.NamespacedName{Namespace: "test", Name: "route"}, uint64(i+1)),
+					Revision: uint64(i + 1),
+				}
+				// An age of 0 stands for revisions stored before the history existed
+				if age > 0 {
+					revision.CreatedAt = now.Add(-age)
+				}
+				revisions = append(revisions, revision)
+			}
+
+			expired := make([]uint64, 0)
+			for _, revision := range expiredRevisions(revisions, now, keptRevisions(quota)) {
+				expired = append(expired, revision.Revision)
+			}
+			if !reflect.DeepEqual(expired, tt.want) {
+				t.Errorf("expired revisions %v, want %v", expired, tt.want)
+			}
+		})
+	}
+}
+
+func TestPruneRouteRevisions(t *testing.T) {
+	connector := newTestConnector(t)
+	connector.cache = newTestReadCache(t, 0)
+	ctx := context.Background()
+	namespace := newTestNamespace(t, connector)
+	service := newTestService(t, connector, namespace, "backend")
+	name := &protoStorage.NamespacedName{Namespace: namespace, Name: "route"}
+	// SetRoute prunes as well, all revisions are kept until they are cached
+	setRetentionPolicy(t, 0, 0)
+
+	uids := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		uid, err := connector.SetRoute(ctx, name, newTestRoute(namespace+".example.com", service))
+		if err != nil {
+			t.Fatal(err)
+		}
+		uids = append(uids, uid)
+	}
+	// Cache the revision that is pruned
+	err := connector.GetRoute(ctx, uids[0], &protoStorage.Route{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = connector.GetRouteStep(ctx, uids[0], 0, &protoStorage.RouteStep{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setRetentionPolicy(t, 1, 0)
+	count, err := connector.PruneRouteRevisions(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Route, steps and references of the oldest revision
+	if count != 3 {
+		t.Errorf("pruned %v keys, want 3", count)
+	}
+
+	err = connector.GetRoute(ctx, uids[0], &protoStorage.Route{})
+	if err != ErrorNotFound {
+		t.Errorf("GetRoute of pruned revision returned %v, want %v", err, ErrorNotFound)
+	}
+	err = connector.GetRouteStep(ctx, uids[0], 0, &protoStorage.RouteStep{})
+	if err != ErrorNotFound {
+		t.Errorf("GetRouteStep of pruned revision returned %v, want %v", err, ErrorNotFound)
+	}
+	for _, uid := range uids[1:] {
+		err = connector.GetRoute(ctx, uid, &protoStorage.Route{})
+		if err != nil {
+			t.Errorf("kept revision %s could not be read: %v", uid, err)
+		}
+	}
+
+	count, err = connector.PruneRouteRevisions(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("second prune removed %v keys, want 0", count)
+	}
+}
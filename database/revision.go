@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type RouteRevision struct {
+	Uid      string
+	Revision uint64
+	// Zero for revisions stored before the history existed
+	CreatedAt time.Time
+}
+
+func dbRouteHistoryName(namespacedName *protoStorage.NamespacedName) string {
+	return dbNamespaceKey(namespacedName.Namespace, "history/routes/"+namespacedName.Namespace+":"+namespacedName.Name)
+}
+
+func addRouteRevisionTx(ctx context.Context, tx redis.Pipeliner, namespacedName *protoStorage.NamespacedName, revision uint64) {
+	tx.ZAdd(ctx, dbRouteHistoryName(namespacedName), &redis.Z{
+		Score:  float64(time.Now().Unix()),
+		Member: revision,
+	})
+}
+
+// addLegacyRouteRevisionsTx records revisions stored before the history existed, a score of 0 marks
+// their creation time as unknown
+func addLegacyRouteRevisionsTx(ctx context.Context, tx redis.Pipeliner, namespacedName *protoStorage.NamespacedName, revisions []*RouteRevision) {
+	if len(revisions) == 0 {
+		return
+	}
+
+	members := make([]*redis.Z, 0, len(revisions))
+	for _, revision := range revisions {
+		members = append(members, &redis.Z{Score: 0, Member: revision.Revision})
+	}
+	tx.ZAdd(ctx, dbRouteHistoryName(namespacedName), members...)
+}
+
+// GetRouteRevisions returns all stored revisions of a route ordered from oldest to newest
+func (connector *Connector) GetRouteRevisions(ctx context.Context, namespacedName *protoStorage.NamespacedName) ([]*RouteRevision, error) {
+	ctx, span := startSpan(ctx, "GetRouteRevisions")
+	defer span.End()
+
+	entries, err := connector.redisClient.ZRangeWithScores(ctx, dbRouteHistoryName(namespacedName), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return legacyRouteRevisions(ctx, connector.redisClient, namespacedName)
+	}
+
+	revisions := make([]*RouteRevision, 0, len(entries))
+	for _, entry := range entries {
+		member, ok := entry.Member.(string)
+		if !ok {
+			continue
+		}
+		revision, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		routeRevision := &RouteRevision{
+			Uid:      buildUid(namespacedName, revision),
+			Revision: revision,
+		}
+		if entry.Score > 0 {
+			routeRevision.CreatedAt = time.Unix(int64(entry.Score), 0)
+		}
+		revisions = append(revisions, routeRevision)
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+
+	return revisions, nil
+}
+
+// legacyRouteRevisions returns the revisions of a route stored before the history existed,
+// their creation time is unknown
+func legacyRouteRevisions(ctx context.Context, client redis.Cmdable, namespacedName *protoStorage.NamespacedName) ([]*RouteRevision, error) {
+	latest, err := client.Get(ctx, dbLatestRevisionName(namespacedName)).Uint64()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrorNotFound
+		}
+		return nil, err
+	}
+
+	p := client.Pipeline()
+	exists := make([]*redis.IntCmd, 0, latest)
+	for revision := uint64(1); revision <= latest; revision++ {
+		exists = append(exists, p.Exists(ctx, dbRouteName(buildUid(namespacedName, revision))))
+	}
+	_, err = p.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]*RouteRevision, 0, len(exists))
+	for i, cmd := range exists {
+		if cmd.Val() == 0 {
+			continue
+		}
+		revision := uint64(i + 1)
+		revisions = append(revisions, &RouteRevision{
+			Uid:      buildUid(namespacedName, revision),
+			Revision: revision,
+		})
+	}
+
+	if len(revisions) == 0 {
+		return nil, ErrorNotFound
+	}
+	return revisions, nil
+}
+
+func (connector *Connector) GetRouteRevision(ctx context.Context, namespacedName *protoStorage.NamespacedName, revision uint64, route *protoStorage.Route) error {
+	ctx, span := startSpan(ctx, "GetRouteRevision")
+	defer span.End()
+
+	return connector.GetRoute(ctx, buildUid(namespacedName, revision), route)
+}
+
+// RollbackRoute publishes the given revision again as the new latest revision and returns its uid
+func (connector *Connector) RollbackRoute(ctx context.Context, namespacedName *protoStorage.NamespacedName, revision uint64) (string, error) {
+	ctx, span := startSpan(ctx, "RollbackRoute")
+	defer span.End()
+
+	route := &protoStorage.Route{}
+	err := connector.GetRouteRevision(ctx, namespacedName, revision, route)
+	if err != nil {
+		return "", err
+	}
+
+	match, err := connector.GetRouteMatch(ctx, buildUid(namespacedName, revision))
+	if err != nil {
+		return "", err
+	}
+
+	return connector.SetRouteWithMatch(ctx, namespacedName, route, match)
+}
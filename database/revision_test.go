@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"testing"
+)
+
+func TestGetRouteRevisionsWithoutHistory(t *testing.T) {
+	connector := newTestConnector(t)
+	ctx := context.Background()
+	namespace := newTestNamespace(t, connector)
+	service := newTestService(t, connector, namespace, "backend")
+	name := &protoStorage.NamespacedName{Namespace: namespace, Name: "route"}
+	setRetentionPolicy(t, 0, 0)
+
+	for i := 0; i < 3; i++ {
+		_, err := connector.SetRoute(ctx, name, newTestRoute(namespace+".example.com", service))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Routes stored before the history existed only have the latest revision pointer
+	err := connector.redisClient.Del(ctx, dbRouteHistoryName(name)).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := connector.GetRouteRevisions(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("got %v revisions, want 3", len(revisions))
+	}
+	for i, revision := range revisions {
+		if revision.Revision != uint64(i+1) || revision.Uid != buildUid(name, revision.Revision) {
+			t.Errorf("unexpected revision %+v at %v", revision, i)
+		}
+		if !revision.CreatedAt.IsZero() {
+			t.Errorf("revision %v has creation time %v without history", revision.Revision, revision.CreatedAt)
+		}
+	}
+
+	stats, err := connector.GetNamespaceStats(ctx, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Revisions != 3 {
+		t.Errorf("namespace stats count %v revisions, want 3", stats.Revisions)
+	}
+
+	// Revisions without creation time never expire by age
+	setRetentionPolicy(t, 1, 1)
+	count, err := connector.PruneRouteRevisions(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("pruned %v keys, want 3", count)
+	}
+	revisions, err = connector.GetRouteRevisions(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Revision != 2 {
+		t.Errorf("unexpected revisions after pruning %+v", revisions)
+	}
+
+	setRetentionPolicy(t, 0, 0)
+	uid, err := connector.RollbackRoute(ctx, name, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision := uidRevision(t, uid); revision != 4 {
+		t.Errorf("rollback created revision %v, want 4", revision)
+	}
+
+	// The next write records the older revisions in the history
+	revisions, err = connector.GetRouteRevisions(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("got %v revisions after rollback, want 3", len(revisions))
+	}
+	for i, revision := range revisions {
+		if revision.Revision != uint64(i+2) {
+			t.Errorf("unexpected revision %+v at %v", revision, i)
+		}
+		if revision.CreatedAt.IsZero() != (revision.Revision < 4) {
+			t.Errorf("revision %v has creation time %v", revision.Revision, revision.CreatedAt)
+		}
+	}
+}
+
+func TestGetRouteRevisionsUnknownRoute(t *testing.T) {
+	connector := newTestConnector(t)
+	namespace := newTestNamespace(t, connector)
+
+	_, err := connector.GetRouteRevisions(context.Background(), &protoStorage.NamespacedName{Namespace: namespace, Name: "missing"})
+	if err != ErrorNotFound {
+		t.Errorf("GetRouteRevisions returned %v, want %v", err, ErrorNotFound)
+	}
+}
@@ -2,20 +2,26 @@ package database
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	protoStorage "github.com/kulycloud/protocol/storage"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 var ErrInvalidUid = errors.New("invalid uid")
+var ErrHostConflict = errors.New("host is already used by another route")
+
+// DefaultRouteHost is the host of the route serving all requests no other route matches.
+// Hosts starting with "*." match all subdomains of the remaining suffix.
+const DefaultRouteHost = "*"
 
 type dbRoute struct {
 	Host string `json:"host"`
-	// more to follow
+	RouteMatch
 }
 
 func dbRouteFromProtoRoute(route *protoStorage.Route) *dbRoute {
@@ -28,20 +34,35 @@ func unpackDbRoute(dbRoute *dbRoute, route *protoStorage.Route) {
 	route.Host = dbRoute.Host
 }
 
+// uidNamespace returns the namespace of a uid, keys of revisions are stored with the tag of their namespace
+func uidNamespace(uid string) string {
+	return strings.SplitN(uid, ":", 2)[0]
+}
+
 func dbRouteName(uid string) string {
-	return "routes/" + uid
+	return dbNamespaceKey(uidNamespace(uid), "routes/"+uid)
 }
 
 func dbRouteStepsName(uid string) string {
-	return "routes/" + uid + "/steps"
+	return dbNamespaceKey(uidNamespace(uid), "routes/"+uid+"/steps")
+}
+
+func dbRouteReferencesName(uid string) string {
+	return dbNamespaceKey(uidNamespace(uid), "routes/"+uid+"/references")
 }
 
 func dbNamespaceRoutesName(namespace string) string {
-	return "routes/" + namespace
+	return dbNamespaceKey(namespace, "routes/"+namespace)
 }
 
 func dbLatestRevisionName(namespacedName *protoStorage.NamespacedName) string {
-	return "revisions/routes/" + namespacedName.Namespace + ":" + namespacedName.Name
+	return dbNamespaceKey(namespacedName.Namespace, "revisions/routes/"+namespacedName.Namespace+":"+namespacedName.Name)
+}
+
+// dbRouteRevisionCountersName holds the last allocated revision of every route of a namespace. Counters are kept
+// when a route is deleted, so a recreated route never reuses the uid of a revision that may still be cached.
+func dbRouteRevisionCountersName(namespace string) string {
+	return dbNamespaceKey(namespace, "revision-counters/routes/"+namespace)
 }
 
 func buildUid(namespacedName *protoStorage.NamespacedName, revision uint64) string {
@@ -66,10 +87,35 @@ func ParseUid(uid string) (*protoStorage.NamespacedName, error) {
 }
 
 func dbHostRoute(host string) string {
-	return "hosts/" + host
+	return dbGlobalKey("hosts/" + host)
+}
+
+func isSameName(a *protoStorage.NamespacedName, b *protoStorage.NamespacedName) bool {
+	return a.Namespace == b.Namespace && a.Name == b.Name
+}
+
+func getDbRoute(ctx context.Context, client redis.Cmdable, uid string) (*dbRoute, error) {
+	routeJson, err := client.Get(ctx, dbRouteName(uid)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrorNotFound
+		}
+		return nil, err
+	}
+
+	dbRoute := &dbRoute{}
+	err = decodeDbRoute(routeJson, dbRoute)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbRoute, nil
 }
 
 func (connector *Connector) GetRouteUidLatestRevision(ctx context.Context, namespacedName *protoStorage.NamespacedName) (string, error) {
+	ctx, span := startSpan(ctx, "GetRouteUidLatestRevision")
+	defer span.End()
+
 	revision, err := connector.GetRouteLatestRevision(ctx, namespacedName)
 	if err != nil {
 		return "", err
@@ -78,74 +124,288 @@ func (connector *Connector) GetRouteUidLatestRevision(ctx context.Context, names
 }
 
 func (connector *Connector) GetRouteLatestRevision(ctx context.Context, namespacedName *protoStorage.NamespacedName) (uint64, error) {
-	return connector.redisClient.Get(ctx, dbLatestRevisionName(namespacedName)).Uint64()
+	ctx, span := startSpan(ctx, "GetRouteLatestRevision")
+	defer span.End()
+
+	key := dbLatestRevisionName(namespacedName)
+	if revision, ok := connector.cache.pointers.Get(key); ok {
+		return revision.(uint64), nil
+	}
+
+	generation := connector.cache.currentGeneration()
+	revision, err := connector.redisClient.Get(ctx, key).Uint64()
+	if err != nil {
+		return 0, err
+	}
+
+	connector.cache.addMutable(connector.cache.pointers, key, revision, generation)
+	return revision, nil
 }
 
 func (connector *Connector) SetRoute(ctx context.Context, namespacedName *protoStorage.NamespacedName, route *protoStorage.Route) (string, error) {
+	ctx, span := startSpan(ctx, "SetRoute")
+	defer span.End()
+
+	return connector.SetRouteWithMatch(ctx, namespacedName, route, nil)
+}
+
+// SetRouteWithMatch stores a new revision of a route only serving requests fulfilling match, a nil match
+// makes the route the default route of its host
+func (connector *Connector) SetRouteWithMatch(ctx context.Context, namespacedName *protoStorage.NamespacedName, route *protoStorage.Route, match *RouteMatch) (string, error) {
+	ctx, span := startSpan(ctx, "SetRouteWithMatch")
+	defer span.End()
+
+	problems := checkRouteGraph(route)
+	if len(problems) > 0 {
+		return "", &RouteValidationError{Problems: problems}
+	}
+
 	// First update parent object
-	dbRoute := dbRouteFromProtoRoute(route)
-	str, err := json.Marshal(dbRoute)
+	newRoute := dbRouteFromProtoRoute(route)
+	if match != nil {
+		newRoute.RouteMatch = *match
+	}
+	str, err := encodeDbRoute(newRoute)
 	if err != nil {
 		return "", err
 	}
 
-	revision, err := connector.GetRouteLatestRevision(ctx, namespacedName)
-	if err != nil {
-		if err == redis.Nil {
-			revision = 0
-		} else {
+	steps := make([]interface{}, 0, len(route.Steps))
+	for _, step := range route.Steps {
+		stepStr, err := encodeMessage(step)
+		if err != nil {
 			return "", err
 		}
+		steps = append(steps, stepStr)
 	}
-	revision++
-	uid := buildUid(namespacedName, revision)
-
-	oldUid, err := connector.GetRouteUidLatestRevision(ctx, namespacedName)
-	hasOldUid := true
+	references, err := buildReferenceIndex(route)
 	if err != nil {
-		if err != redis.Nil {
-			return "", err
-		} else {
-			hasOldUid = false
-		}
+		return "", err
 	}
 
-	p := connector.redisClient.TxPipeline()
-	p.Set(ctx, dbRouteName(uid), str, 0)
-	p.Del(ctx, dbRouteStepsName(uid))
-	p.SAdd(ctx, dbNamespaceRoutesName(namespacedName.Namespace), uid)
-	if hasOldUid {
-		p.SRem(ctx, dbNamespaceRoutesName(namespacedName.Namespace), oldUid)
+	var uid string
+	var revision uint64
+	var oldRoute *dbRoute
+	var hasOldUid bool
+	var legacyRevisions []*RouteRevision
+	revisionKey := dbLatestRevisionName(namespacedName)
+	historyKey := dbRouteHistoryName(namespacedName)
+	countersKey := dbRouteRevisionCountersName(namespacedName.Namespace)
+	entries := newRoute.hostEntries()
+	routesKey := dbNamespaceRoutesName(namespacedName.Namespace)
+	routeKeys := []string{revisionKey, countersKey, historyKey, routesKey, dbNamespaceQuotaName(namespacedName.Namespace)}
+	hostKeys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		hostKeys = append(hostKeys, entry.key())
 	}
-	p.Set(ctx, dbHostRoute(route.Host), uid, 0)
-	p.Set(ctx, dbLatestRevisionName(namespacedName), revision, 0)
-	connector.AddNamespaceIfNotExistsTx(ctx, p, namespacedName.Namespace)
 
-	m := jsonpb.Marshaler{}
+	// Referenced services are checked inside the transaction so they cannot be deleted concurrently.
+	// In cluster mode services of other namespaces are checked in parts of their own.
+	parts := make([]txPart, 0)
+	isOwnService := func(service *protoStorage.NamespacedName) bool {
+		return !clusterMode || service.Namespace == namespacedName.Namespace
+	}
+	foreignServiceKeys := make(map[string][]string)
 	for _, step := range route.Steps {
-		stepStr, err := m.MarshalToString(step)
-		if err != nil {
-			return "", err
+		if isOwnService(step.Service) {
+			routeKeys = append(routeKeys, dbServiceName(step.Service))
+		} else {
+			foreignServiceKeys[step.Service.Namespace] = append(foreignServiceKeys[step.Service.Namespace], dbServiceName(step.Service))
 		}
+	}
+	foreignProblems := make(map[string][]*RouteProblem)
+	for namespace, keys := range foreignServiceKeys {
+		namespace := namespace
+		parts = append(parts, txPart{
+			keys: keys,
+			check: func(tx *redis.Tx) (func(p redis.Pipeliner), error) {
+				problems, err := checkServices(ctx, tx, route, func(service *protoStorage.NamespacedName) bool {
+					return service.Namespace == namespace
+				})
+				foreignProblems[namespace] = problems
+				return nil, err
+			},
+		})
+	}
+
+	checkHosts := func(tx *redis.Tx) error {
+		for _, entry := range entries {
+			owned, err := ownsHostEntry(ctx, tx, entry, namespacedName)
+			if err != nil {
+				return err
+			}
+			if !owned {
+				return ErrHostConflict
+			}
+		}
+		return nil
+	}
+	if clusterMode {
+		// Parts are committed one after another, conflicts are detected before the revision is stored
+		parts = append(parts, txPart{
+			keys: hostKeys,
+			check: func(tx *redis.Tx) (func(p redis.Pipeliner), error) {
+				return nil, checkHosts(tx)
+			},
+		})
+	}
 
-		p.RPush(ctx, dbRouteStepsName(uid), stepStr)
+	// The latest revision and the counter are watched so concurrent writers can never allocate the same revision
+	parts = append(parts, txPart{
+		keys: routeKeys,
+		check: func(tx *redis.Tx) (func(p redis.Pipeliner), error) {
+			problems, err := checkServices(ctx, tx, route, isOwnService)
+			if err != nil {
+				return nil, err
+			}
+			for _, foreign := range foreignProblems {
+				problems = append(problems, foreign...)
+			}
+			if len(problems) > 0 {
+				sort.SliceStable(problems, func(i, j int) bool {
+					return problems[i].Step < problems[j].Step
+				})
+				return nil, &RouteValidationError{Problems: problems}
+			}
+
+			latest, err := tx.Get(ctx, revisionKey).Uint64()
+			hasOldUid = true
+			if err != nil {
+				if err != redis.Nil {
+					return nil, err
+				}
+				latest = 0
+				hasOldUid = false
+			}
+			oldUid := buildUid(namespacedName, latest)
+
+			counter, err := tx.HGet(ctx, countersKey, namespacedName.Name).Uint64()
+			if err != nil && err != redis.Nil {
+				return nil, err
+			}
+			// Routes stored before the counters existed only have their latest revision
+			revision = latest
+			if counter > revision {
+				revision = counter
+			}
+			revision++
+
+			quota, err := getQuota(ctx, tx, namespacedName.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			err = checkLimit("steps per route", quota.MaxStepsPerRoute, int64(len(steps)))
+			if err != nil {
+				return nil, err
+			}
+			if !hasOldUid {
+				err = checkCountQuota(ctx, tx, routesKey, "routes", quota.MaxRoutes)
+				if err != nil {
+					return nil, err
+				}
+			}
+			uid = buildUid(namespacedName, revision)
+
+			oldRoute = nil
+			legacyRevisions = nil
+			if hasOldUid {
+				oldRoute, err = getDbRoute(ctx, tx, oldUid)
+				if err != nil && err != ErrorNotFound {
+					return nil, err
+				}
+
+				// Routes stored before the history existed get their revisions recorded on their next write
+				recorded, err := tx.ZCard(ctx, historyKey).Result()
+				if err != nil {
+					return nil, err
+				}
+				if recorded == 0 {
+					legacyRevisions, err = legacyRouteRevisions(ctx, tx, namespacedName)
+					if err != nil && err != ErrorNotFound {
+						return nil, err
+					}
+				}
+			}
+
+			return func(p redis.Pipeliner) {
+				p.Set(ctx, dbRouteName(uid), str, 0)
+				p.Del(ctx, dbRouteStepsName(uid))
+				p.Del(ctx, dbRouteReferencesName(uid))
+				p.SAdd(ctx, routesKey, uid)
+				if hasOldUid {
+					p.SRem(ctx, routesKey, oldUid)
+				}
+				p.Set(ctx, revisionKey, revision, 0)
+				p.HSet(ctx, countersKey, namespacedName.Name, revision)
+				addLegacyRouteRevisionsTx(ctx, p, namespacedName, legacyRevisions)
+				addRouteRevisionTx(ctx, p, namespacedName, revision)
+
+				if len(steps) > 0 {
+					p.RPush(ctx, dbRouteStepsName(uid), steps...)
+					p.HSet(ctx, dbRouteReferencesName(uid), references)
+				}
+			}, nil
+		},
+	}, txPart{
+		keys: hostKeys,
+		check: func(tx *redis.Tx) (func(p redis.Pipeliner), error) {
+			err := checkHosts(tx)
+			if err != nil {
+				return nil, err
+			}
+
+			// Host index entries of the previous revision not used anymore have to be released
+			staleEntries := make([]hostEntry, 0)
+			if oldRoute != nil {
+				staleEntries, err = ownedStaleEntries(ctx, tx, oldRoute.hostEntries(), entries, namespacedName)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			eventType := Created
+			if hasOldUid {
+				eventType = Updated
+			}
+
+			return func(p redis.Pipeliner) {
+				for _, entry := range staleEntries {
+					entry.del(ctx, p)
+				}
+				for _, entry := range entries {
+					entry.set(ctx, p, uid)
+				}
+				connector.AddNamespaceIfNotExistsTx(ctx, p, namespacedName.Namespace)
+				publishEvent(ctx, p, &Event{Kind: RouteEvent, Type: eventType, NamespacedName: namespacedName, Uid: uid, Revision: revision})
+			}, nil
+		},
+	})
+
+	err = connector.transaction(ctx, parts...)
+	if err != nil {
+		return "", err
+	}
+	connector.cache.invalidate(&Event{Kind: RouteEvent, Type: Updated, NamespacedName: namespacedName})
+
+	_, err = connector.PruneRouteRevisions(ctx, namespacedName)
+	if err != nil {
+		logger.Warnw("Could not prune route revisions", "route", uid, "error", err)
 	}
 
-	_, err = p.Exec(ctx)
-	return uid, err
+	return uid, nil
 }
 
 func (connector *Connector) GetRoute(ctx context.Context, uid string, route *protoStorage.Route) error {
-	routeJson, err := connector.redisClient.Get(ctx, dbRouteName(uid)).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return ErrorNotFound
-		}
-		return err
+	ctx, span := startSpan(ctx, "GetRoute")
+	defer span.End()
+
+	cacheKey := revisionCacheKey(uid, "route")
+	if cached, ok := connector.cache.revisions.Get(cacheKey); ok {
+		copyInto(route, cached.(*protoStorage.Route))
+		return nil
 	}
 
-	dbRoute := &dbRoute{}
-	err = json.Unmarshal([]byte(routeJson), dbRoute)
+	dbRoute, err := getDbRoute(ctx, connector.redisClient, uid)
 	if err != nil {
 		return err
 	}
@@ -160,20 +420,25 @@ func (connector *Connector) GetRoute(ctx context.Context, uid string, route *pro
 	route.Steps = make([]*protoStorage.RouteStep, 0)
 	for _, stepJson := range op.Val() {
 		step := &protoStorage.RouteStep{}
-		err = jsonpb.Unmarshal(strings.NewReader(stepJson), step)
+		err = decodeMessage(stepJson, step)
 		if err != nil {
 			return err
 		}
 		route.Steps = append(route.Steps, step)
 	}
 
+	connector.cache.revisions.Add(cacheKey, proto.Clone(route), 0)
 	return nil
 }
 
 func (connector *Connector) GetRouteStep(ctx context.Context, uid string, id uint32, step *protoStorage.RouteStep) error {
-	op := connector.redisClient.LRange(ctx, dbRouteStepsName(uid), 0, -1)
-	if op.Err() != nil {
-		return op.Err()
+	ctx, span := startSpan(ctx, "GetRouteStep")
+	defer span.End()
+
+	cacheKey := revisionCacheKey(uid, fmt.Sprintf("steps/%v", id))
+	if cached, ok := connector.cache.revisions.Get(cacheKey); ok {
+		copyInto(step, cached.(*protoStorage.RouteStep))
+		return nil
 	}
 
 	stepJson, err := connector.redisClient.LIndex(ctx, dbRouteStepsName(uid), int64(id)).Result()
@@ -183,65 +448,114 @@ func (connector *Connector) GetRouteStep(ctx context.Context, uid string, id uin
 		}
 		return err
 	}
-	return jsonpb.Unmarshal(strings.NewReader(stepJson), step)
-}
+	err = decodeMessage(stepJson, step)
+	if err != nil {
+		return err
+	}
 
-func (connector *Connector) GetRoutesInNamespace(ctx context.Context, namespace string) ([]string, error) {
-	return connector.redisClient.SMembers(ctx, dbNamespaceRoutesName(namespace)).Result()
+	connector.cache.revisions.Add(cacheKey, proto.Clone(step), 0)
+	return nil
 }
 
-func (connector *Connector) GetRouteUidByHost(ctx context.Context, host string) (string, error) {
-	res, err := connector.redisClient.Get(ctx, dbHostRoute(host)).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return "", ErrorNotFound
-		}
-		return "", err
-	}
+func (connector *Connector) GetRoutesInNamespace(ctx context.Context, namespace string) ([]string, error) {
+	ctx, span := startSpan(ctx, "GetRoutesInNamespace")
+	defer span.End()
 
-	return res, nil
+	return connector.redisClient.SMembers(ctx, dbNamespaceRoutesName(namespace)).Result()
 }
 
 func (connector *Connector) DeleteRoute(ctx context.Context, namespacedName *protoStorage.NamespacedName) error {
-	revision, err := connector.GetRouteLatestRevision(ctx, namespacedName)
-	if err != nil {
-		return err
-	}
-
-	route := &protoStorage.Route{}
-	uid := buildUid(namespacedName, revision)
+	ctx, span := startSpan(ctx, "DeleteRoute")
+	defer span.End()
+
+	var event *Event
+	var route *dbRoute
+	revisionKey := dbLatestRevisionName(namespacedName)
+	historyKey := dbRouteHistoryName(namespacedName)
+
+	// The latest revision is read from redis inside the transaction, a cached one may already be outdated.
+	// The host index is released last, so in cluster mode an interrupted delete leaves entries of a deleted route
+	// which are free to be taken over.
+	err := connector.transaction(ctx, txPart{
+		keys: []string{revisionKey, historyKey},
+		check: func(tx *redis.Tx) (func(p redis.Pipeliner), error) {
+			revision, err := tx.Get(ctx, revisionKey).Uint64()
+			if err != nil {
+				if err == redis.Nil {
+					return nil, ErrorNotFound
+				}
+				return nil, err
+			}
+			uid := buildUid(namespacedName, revision)
+
+			route, err = getDbRoute(ctx, tx, uid)
+			if err != nil {
+				return nil, err
+			}
+
+			revisions, err := storedRevisions(ctx, tx, namespacedName, revision)
+			if err != nil {
+				return nil, err
+			}
+
+			event = &Event{Kind: RouteEvent, Type: Deleted, NamespacedName: namespacedName, Uid: uid, Revision: revision}
+			return func(p redis.Pipeliner) {
+				p.Del(ctx, revisionKey)
+				p.Del(ctx, historyKey)
+				for _, revision := range revisions {
+					revUid := buildUid(namespacedName, revision)
+					p.Del(ctx, dbRouteName(revUid), dbRouteStepsName(revUid), dbRouteReferencesName(revUid))
+				}
+				p.SRem(ctx, dbNamespaceRoutesName(namespacedName.Namespace), uid)
+			}, nil
+		},
+	}, txPart{
+		// Only selects the slot of the host index, the entries are watched once the route has been read
+		keys: []string{dbGlobalKey(dbNamespacesName)},
+		check: func(tx *redis.Tx) (func(p redis.Pipeliner), error) {
+			entries, err := ownedStaleEntries(ctx, tx, route.hostEntries(), nil, namespacedName)
+			if err != nil {
+				return nil, err
+			}
+
+			return func(p redis.Pipeliner) {
+				for _, entry := range entries {
+					entry.del(ctx, p)
+				}
+				publishEvent(ctx, p, event)
+			}, nil
+		},
+	})
 
-	err = connector.GetRoute(ctx, buildUid(namespacedName, revision), route)
 	if err != nil {
 		return err
 	}
+	connector.cache.invalidate(event)
 
-	tx := connector.redisClient.TxPipeline()
-	tx.Del(ctx, dbHostRoute(route.Host))
-	tx.Del(ctx, dbLatestRevisionName(namespacedName))
-	tx.Del(ctx, dbRouteName(uid))
-	tx.Del(ctx, dbRouteStepsName(uid))
-	tx.SRem(ctx, dbNamespaceRoutesName(namespacedName.Namespace), uid)
-	_, err = tx.Exec(ctx)
+	return connector.DeleteNamespaceIfEmpty(ctx, namespacedName.Namespace)
+}
 
+// storedRevisions returns all revisions of a route listed in its history including the latest one.
+// Routes stored before the history existed have all revisions up to the latest one.
+func storedRevisions(ctx context.Context, client redis.Cmdable, namespacedName *protoStorage.NamespacedName, latest uint64) ([]uint64, error) {
+	members, err := client.ZRange(ctx, dbRouteHistoryName(namespacedName), 0, -1).Result()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = connector.DeleteNamespaceIfEmpty(ctx, namespacedName.Namespace)
-	if err != nil {
-		return err
+	revisions := []uint64{latest}
+	for _, member := range members {
+		revision, err := strconv.ParseUint(member, 10, 64)
+		if err == nil && revision != latest {
+			revisions = append(revisions, revision)
+		}
 	}
-	// Delete all old revisions until we get an error (= revisions does not exist)
 
-	err = nil
-	for rev := revision-1; rev > 0 && err == nil; rev-- {
-		revUid := buildUid(namespacedName, rev)
-		tx := connector.redisClient.TxPipeline()
-		tx.Del(ctx, dbRouteName(revUid))
-		tx.Del(ctx, dbRouteStepsName(revUid))
-		_, err = tx.Exec(ctx)
+	if len(members) == 0 {
+		for revision := latest - 1; revision > 0; revision-- {
+			revisions = append(revisions, revision)
+		}
 	}
 
-	return nil
+	return revisions, nil
 }
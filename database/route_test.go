@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func uidRevision(t *testing.T, uid string) uint64 {
+	t.Helper()
+
+	parts := strings.SplitN(uid, "@", 2)
+	if len(parts) != 2 {
+		t.Fatalf("invalid uid %s", uid)
+	}
+	revision, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		t.Fatalf("invalid uid %s: %v", uid, err)
+	}
+	return revision
+}
+
+func TestSetRouteConcurrentRevisions(t *testing.T) {
+	connector := newTestConnector(t)
+	ctx := context.Background()
+	namespace := newTestNamespace(t, connector)
+	service := newTestService(t, connector, namespace, "backend")
+	name := &protoStorage.NamespacedName{Namespace: namespace, Name: "route"}
+
+	const writers = 20
+	uids := make(chan string, writers)
+	errs := make(chan error, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			uid, err := connector.SetRoute(ctx, name, newTestRoute(namespace+".example.com", service))
+			if err != nil {
+				errs <- err
+				return
+			}
+			uids <- uid
+		}()
+	}
+	wg.Wait()
+	close(uids)
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("SetRoute failed: %v", err)
+	}
+
+	revisions := make(map[uint64]bool)
+	for uid := range uids {
+		revision := uidRevision(t, uid)
+		if revisions[revision] {
+			t.Errorf("revision %v was allocated twice", revision)
+		}
+		revisions[revision] = true
+	}
+	for revision := uint64(1); revision <= writers; revision++ {
+		if !revisions[revision] {
+			t.Errorf("revision %v is missing", revision)
+		}
+	}
+
+	latest, err := connector.redisClient.Get(ctx, dbLatestRevisionName(name)).Uint64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest != writers {
+		t.Errorf("latest revision is %v, want %v", latest, writers)
+	}
+}
+
+func TestDeleteRouteKeepsRevisionCounter(t *testing.T) {
+	connector := newTestConnector(t)
+	ctx := context.Background()
+	namespace := newTestNamespace(t, connector)
+	service := newTestService(t, connector, namespace, "backend")
+	name := &protoStorage.NamespacedName{Namespace: namespace, Name: "route"}
+	route := newTestRoute(namespace+".example.com", service)
+
+	var uid string
+	var err error
+	for i := 0; i < 2; i++ {
+		uid, err = connector.SetRoute(ctx, name, route)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = connector.DeleteRoute(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = connector.GetRoute(ctx, uid, &protoStorage.Route{})
+	if err != ErrorNotFound {
+		t.Errorf("GetRoute of deleted revision returned %v, want %v", err, ErrorNotFound)
+	}
+	err = connector.DeleteRoute(ctx, name)
+	if err != ErrorNotFound {
+		t.Errorf("second DeleteRoute returned %v, want %v", err, ErrorNotFound)
+	}
+
+	recreated, err := connector.SetRoute(ctx, name, route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision := uidRevision(t, recreated); revision != 3 {
+		t.Errorf("recreated route has revision %v, want 3", revision)
+	}
+}
+
+func TestSetRouteUnknownService(t *testing.T) {
+	connector := newTestConnector(t)
+	ctx := context.Background()
+	namespace := newTestNamespace(t, connector)
+	service := &protoStorage.NamespacedName{Namespace: namespace, Name: "missing"}
+	name := &protoStorage.NamespacedName{Namespace: namespace, Name: "route"}
+
+	_, err := connector.SetRoute(ctx, name, newTestRoute(namespace+".example.com", service))
+	validationErr, ok := err.(*RouteValidationError)
+	if !ok {
+		t.Fatalf("SetRoute returned %v, want a validation error", err)
+	}
+	if len(validationErr.Problems) != 1 || validationErr.Problems[0].Step != 0 {
+		t.Errorf("unexpected problems %v", validationErr)
+	}
+
+	_, err = connector.GetRouteUidLatestRevision(ctx, name)
+	if err == nil {
+		t.Error("route with unknown service has been stored")
+	}
+}
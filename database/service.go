@@ -3,37 +3,70 @@ package database
 import (
 	"context"
 	"github.com/go-redis/redis/v8"
-	"github.com/golang/protobuf/jsonpb"
 	protoStorage "github.com/kulycloud/protocol/storage"
-	"strings"
 )
 
 func dbServiceName(namespacedName *protoStorage.NamespacedName) string {
-	return "services/" + namespacedName.Namespace + ":" + namespacedName.Name
+	return dbNamespaceKey(namespacedName.Namespace, "services/"+namespacedName.Namespace+":"+namespacedName.Name)
 }
 
 func dbNamespaceServicesName(namespace string) string {
-	return "services/" + namespace
+	return dbNamespaceKey(namespace, "services/"+namespace)
 }
 
 func (connector *Connector) SetService(ctx context.Context, namespacedName *protoStorage.NamespacedName, service *protoStorage.Service) error {
-	m := jsonpb.Marshaler{}
-	serviceStr, err := m.MarshalToString(service)
+	ctx, span := startSpan(ctx, "SetService")
+	defer span.End()
+
+	serviceStr, err := encodeMessage(service)
 	if err != nil {
 		return err
 	}
 	// First update parent object
+	servicesKey := dbNamespaceServicesName(namespacedName.Namespace)
+	var eventType EventType
+
+	return connector.transaction(ctx, txPart{
+		keys: []string{servicesKey, dbNamespaceQuotaName(namespacedName.Namespace)},
+		check: func(tx *redis.Tx) (func(p redis.Pipeliner), error) {
+			exists, err := tx.SIsMember(ctx, servicesKey, namespacedName.Name).Result()
+			if err != nil {
+				return nil, err
+			}
 
-	p := connector.redisClient.TxPipeline()
-	p.Set(ctx, dbServiceName(namespacedName), serviceStr, 0)
-	p.SAdd(ctx, dbNamespaceServicesName(namespacedName.Namespace), namespacedName.Name)
-	connector.AddNamespaceIfNotExistsTx(ctx, p, namespacedName.Namespace)
+			eventType = Updated
+			if !exists {
+				eventType = Created
+				quota, err := getQuota(ctx, tx, namespacedName.Namespace)
+				if err != nil {
+					return nil, err
+				}
+				err = checkCountQuota(ctx, tx, servicesKey, "services", quota.MaxServices)
+				if err != nil {
+					return nil, err
+				}
+			}
 
-	_, err = p.Exec(ctx)
-	return err
+			return func(p redis.Pipeliner) {
+				p.Set(ctx, dbServiceName(namespacedName), serviceStr, 0)
+				p.SAdd(ctx, servicesKey, namespacedName.Name)
+			}, nil
+		},
+	}, txPart{
+		keys: []string{dbGlobalKey(dbNamespacesName)},
+		check: func(*redis.Tx) (func(p redis.Pipeliner), error) {
+			return func(p redis.Pipeliner) {
+				connector.AddNamespaceIfNotExistsTx(ctx, p, namespacedName.Namespace)
+				publishEvent(ctx, p, &Event{Kind: ServiceEvent, Type: eventType, NamespacedName: namespacedName})
+			}, nil
+		},
+	})
 }
 
 func (connector *Connector) GetService(ctx context.Context, name *protoStorage.NamespacedName, service *protoStorage.Service) error {
+	ctx, span := startSpan(ctx, "GetService")
+	defer span.End()
+
 	serviceJson, err := connector.redisClient.Get(ctx, dbServiceName(name)).Result()
 	if err != nil {
 		if err == redis.Nil {
@@ -42,19 +75,31 @@ func (connector *Connector) GetService(ctx context.Context, name *protoStorage.N
 		return err
 	}
 
-	return jsonpb.Unmarshal(strings.NewReader(serviceJson), service)
+	return decodeMessage(serviceJson, service)
 }
 
 func (connector *Connector) GetServicesInNamespace(ctx context.Context, namespace string) ([]string, error) {
+	ctx, span := startSpan(ctx, "GetServicesInNamespace")
+	defer span.End()
+
 	return connector.redisClient.SMembers(ctx, dbNamespaceServicesName(namespace)).Result()
 }
 
 func (connector *Connector) DeleteService(ctx context.Context, namespacedName *protoStorage.NamespacedName) error {
-	tx := connector.redisClient.TxPipeline()
-	tx.Del(ctx, dbServiceName(namespacedName))
-	tx.SRem(ctx, dbNamespaceServicesName(namespacedName.Namespace), namespacedName.Name)
+	ctx, span := startSpan(ctx, "DeleteService")
+	defer span.End()
 
-	_, err := tx.Exec(ctx)
+	servicesKey := dbNamespaceServicesName(namespacedName.Namespace)
+	err := connector.transaction(ctx, txPart{
+		keys: []string{servicesKey},
+		check: func(*redis.Tx) (func(p redis.Pipeliner), error) {
+			return func(p redis.Pipeliner) {
+				p.Del(ctx, dbServiceName(namespacedName))
+				p.SRem(ctx, servicesKey, namespacedName.Name)
+				publishEvent(ctx, p, &Event{Kind: ServiceEvent, Type: Deleted, NamespacedName: namespacedName})
+			}, nil
+		},
+	})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+	protoCommon "github.com/kulycloud/protocol/common"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/metrics"
+)
+
+// Store is the storage backend used to serve requests
+type Store interface {
+	// Routes
+	SetRoute(ctx context.Context, namespacedName *protoStorage.NamespacedName, route *protoStorage.Route) (string, error)
+	GetRoute(ctx context.Context, uid string, route *protoStorage.Route) error
+	GetRouteStep(ctx context.Context, uid string, id uint32, step *protoStorage.RouteStep) error
+	GetPopulatedRouteStep(ctx context.Context, uid string, id uint32) (*protoStorage.PopulatedRouteStep, error)
+	GetPopulatedRoute(ctx context.Context, uid string) (*PopulatedRoute, error)
+	GetPopulatedRouteByHost(ctx context.Context, host string) (*PopulatedRoute, error)
+	GetRouteUidLatestRevision(ctx context.Context, namespacedName *protoStorage.NamespacedName) (string, error)
+	GetRouteUidByHost(ctx context.Context, host string) (string, error)
+	GetRoutesInNamespace(ctx context.Context, namespace string) ([]string, error)
+	DeleteRoute(ctx context.Context, namespacedName *protoStorage.NamespacedName) error
+
+	// Services
+	SetService(ctx context.Context, namespacedName *protoStorage.NamespacedName, service *protoStorage.Service) error
+	GetService(ctx context.Context, name *protoStorage.NamespacedName, service *protoStorage.Service) error
+	GetServicesInNamespace(ctx context.Context, namespace string) ([]string, error)
+	DeleteService(ctx context.Context, namespacedName *protoStorage.NamespacedName) error
+
+	// Endpoints
+	SetEndpoints(ctx context.Context, endpointType EndpointType, name *protoStorage.NamespacedName, endpoints *protoCommon.EndpointList) error
+	GetEndpoints(ctx context.Context, endpointType EndpointType, name *protoStorage.NamespacedName) (*protoCommon.EndpointList, error)
+
+	// Namespaces
+	GetNamespaces(ctx context.Context) ([]string, error)
+	ExistsNamespace(ctx context.Context, name string) (bool, error)
+
+	// Ping checks if the backend is available
+	Ping(ctx context.Context) error
+}
+
+var _ Store = &Connector{}
+var _ metrics.StatsSource = &Connector{}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/kulycloud/storage-redis/config"
+	"io/ioutil"
+)
+
+var ErrInvalidCaBundle = errors.New("no certificates found in CA bundle")
+
+// newTlsConfig builds the TLS configuration of the redis connection, nil disables TLS
+func newTlsConfig() (*tls.Config, error) {
+	if !config.GlobalConfig.RedisTls {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         config.GlobalConfig.RedisTlsServerName,
+		InsecureSkipVerify: config.GlobalConfig.RedisTlsInsecureSkipVerify,
+	}
+
+	if config.GlobalConfig.RedisTlsCaFile != "" {
+		ca, err := ioutil.ReadFile(config.GlobalConfig.RedisTlsCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, ErrInvalidCaBundle
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.GlobalConfig.RedisTlsCertFile != "" || config.GlobalConfig.RedisTlsKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.GlobalConfig.RedisTlsCertFile, config.GlobalConfig.RedisTlsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
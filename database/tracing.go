@@ -0,0 +1,11 @@
+package database
+
+import (
+	"context"
+	"github.com/kulycloud/storage-redis/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+)
+
+func startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracing.StartSpan(ctx, "Connector."+method)
+}
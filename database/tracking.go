@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/kulycloud/storage-redis/config"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Invalidation messages of redis client-side caching are published on this channel in RESP2 redirect mode
+const trackingInvalidationChannel = "__redis__:invalidate"
+
+// trackedPrefixes are the key prefixes of all data cached with ttl that changes in place.
+// Tracking is not used in cluster mode, so keys have no hash tag.
+var trackedPrefixes = []string{"hosts/", "endpoints/", "revisions/routes/"}
+
+// enableTracking turns on broadcast tracking of all tracked prefixes on cn and redirects the invalidation
+// messages to cn itself, so subscribing cn to the invalidation channel receives them
+func enableTracking(ctx context.Context, cn *redis.Conn) error {
+	id, err := cn.ClientID(ctx).Result()
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{"client", "tracking", "on", "redirect", id, "bcast"}
+	for _, prefix := range trackedPrefixes {
+		args = append(args, "prefix", prefix)
+	}
+	return cn.Process(ctx, redis.NewCmd(ctx, args...))
+}
+
+func (cache *readCache) setTracking(active bool) {
+	var value int32
+	if active {
+		value = 1
+	}
+
+	if atomic.SwapInt32(&cache.tracking, value) != value {
+		cache.invalidateAllMutable()
+	}
+}
+
+// invalidateAllMutable removes all entries of requests and pointers, used when invalidation messages may have been lost
+func (cache *readCache) invalidateAllMutable() {
+	atomic.AddUint64(&cache.generation, 1)
+	cache.requests.Purge()
+	cache.pointers.Purge()
+}
+
+func (cache *readCache) invalidateKeys(keys []string) {
+	atomic.AddUint64(&cache.generation, 1)
+	for _, key := range keys {
+		if strings.HasPrefix(key, "hosts/") {
+			cache.requests.Purge()
+		} else {
+			cache.pointers.Remove(key)
+		}
+	}
+}
+
+// handleInvalidation applies an invalidation message, receiving one means tracking is active
+func (cache *readCache) handleInvalidation(msg *redis.Message) {
+	cache.setTracking(true)
+	cache.invalidateKeys(msg.PayloadSlice)
+	if msg.Payload != "" {
+		cache.invalidateKeys([]string{msg.Payload})
+	}
+}
+
+// RunClientTracking uses redis client-side caching to invalidate cached host resolutions, latest revisions and endpoints
+// exactly, so they are cached without ttl. Falls back to the ttl based cache if the server does not support tracking
+// or the connection is lost. Runs until the context is cancelled.
+func (connector *Connector) RunClientTracking(ctx context.Context) {
+	if config.GlobalConfig.RedisMode == "cluster" {
+		logger.Warn("Client tracking is not supported in cluster mode, falling back to ttl based caching")
+		return
+	}
+
+	var trackingErr error
+	client, err := newRedisClient(func(ctx context.Context, cn *redis.Conn) error {
+		// The connection stays usable for events if tracking is not supported
+		trackingErr = enableTracking(ctx, cn)
+		return nil
+	})
+	if err != nil {
+		logger.Warnw("Could not create client tracking connection", "error", err)
+		return
+	}
+	defer client.Close()
+
+	pubsub := client.Subscribe(ctx, trackingInvalidationChannel)
+	defer pubsub.Close()
+	defer connector.cache.setTracking(false)
+
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// Connection errors and flush notifications without keys both require dropping everything.
+			// Tracking is switched on again by the next subscription confirmation or invalidation message.
+			logger.Debugw("Client tracking interrupted", "error", err)
+			connector.cache.setTracking(false)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			// Sent after every (re)connect, switching tracking on drops entries cached while it was off
+			if trackingErr != nil {
+				logger.Warnw("Redis does not support client tracking, falling back to ttl based caching", "error", trackingErr)
+				connector.cache.setTracking(false)
+				return
+			}
+			connector.cache.setTracking(true)
+			logger.Info("Client tracking enabled")
+		case *redis.Message:
+			connector.cache.handleInvalidation(msg)
+		}
+	}
+}
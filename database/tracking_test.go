@@ -0,0 +1,76 @@
+package database
+
+import (
+	"github.com/go-redis/redis/v8"
+	protoStorage "github.com/kulycloud/protocol/storage"
+	"github.com/kulycloud/storage-redis/config"
+	"testing"
+)
+
+func newTestReadCache(t *testing.T, ttl uint32) *readCache {
+	t.Helper()
+
+	size, cacheTtl := config.GlobalConfig.CacheSize, config.GlobalConfig.CacheTtl
+	t.Cleanup(func() {
+		config.GlobalConfig.CacheSize, config.GlobalConfig.CacheTtl = size, cacheTtl
+	})
+	config.GlobalConfig.CacheSize = 10
+	config.GlobalConfig.CacheTtl = ttl
+	return newReadCache()
+}
+
+func invalidationMessage(keys ...string) *redis.Message {
+	return &redis.Message{Channel: trackingInvalidationChannel, PayloadSlice: keys}
+}
+
+func TestTrackingInvalidation(t *testing.T) {
+	cache := newTestReadCache(t, 0)
+	key := dbEndpointsName(ServiceLBEndpoints, &protoStorage.NamespacedName{Namespace: "test", Name: "backend"})
+
+	// Without tracking and a ttl of 0 nothing is cached
+	cache.addMutable(cache.pointers, key, "old", cache.currentGeneration())
+	if _, ok := cache.pointers.Get(key); ok {
+		t.Error("value was cached without tracking and ttl")
+	}
+
+	cache.handleInvalidation(invalidationMessage())
+	generation := cache.currentGeneration()
+	cache.addMutable(cache.pointers, key, "old", generation)
+	cache.addMutable(cache.requests, requestCacheKey("example.com", "/", "GET"), "uid", generation)
+	if _, ok := cache.pointers.Get(key); !ok {
+		t.Fatal("value was not cached while tracking")
+	}
+
+	cache.handleInvalidation(invalidationMessage(key))
+	if _, ok := cache.pointers.Get(key); ok {
+		t.Error("invalidated key is still cached")
+	}
+	if _, ok := cache.requests.Get(requestCacheKey("example.com", "/", "GET")); !ok {
+		t.Error("host resolutions were dropped by an endpoints invalidation")
+	}
+
+	// A value read before the invalidation must not be cached without ttl
+	cache.addMutable(cache.pointers, key, "old", generation)
+	if _, ok := cache.pointers.Get(key); ok {
+		t.Error("stale value was cached after its invalidation")
+	}
+
+	cache.handleInvalidation(&redis.Message{Channel: trackingInvalidationChannel, Payload: dbHostRoute("example.com")})
+	if _, ok := cache.requests.Get(requestCacheKey("example.com", "/", "GET")); ok {
+		t.Error("host resolutions were kept after a host invalidation")
+	}
+}
+
+func TestTrackingInterrupted(t *testing.T) {
+	cache := newTestReadCache(t, 0)
+	key := dbEndpointsName(ServiceLBEndpoints, &protoStorage.NamespacedName{Namespace: "test", Name: "backend"})
+
+	cache.handleInvalidation(invalidationMessage())
+	cache.addMutable(cache.pointers, key, "value", cache.currentGeneration())
+
+	// Invalidation messages may have been lost while tracking was off
+	cache.setTracking(false)
+	if _, ok := cache.pointers.Get(key); ok {
+		t.Error("value is still cached after tracking was interrupted")
+	}
+}
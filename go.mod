@@ -4,7 +4,14 @@ go 1.15
 
 require (
 	github.com/go-redis/redis/v8 v8.2.3
-	github.com/golang/protobuf v1.4.2
+	github.com/golang/protobuf v1.4.3
 	github.com/kulycloud/common v0.0.0-20210323100819-93d825d597b5
 	github.com/kulycloud/protocol v0.0.0-20210323100304-4caa455444f5
+	github.com/prometheus/client_golang v1.9.0
+	go.opentelemetry.io/otel v0.11.0
+	go.opentelemetry.io/otel/exporters/otlp v0.11.0
+	go.opentelemetry.io/otel/sdk v0.11.0
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
+	google.golang.org/grpc v1.32.0
+	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,129 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/kulycloud/common/logging"
+	"github.com/kulycloud/storage-redis/database"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var logger = logging.GetForComponent("health")
+
+// storageService is the service name of the storage in the gRPC health service
+const storageService = "Storage"
+
+// Checker periodically pings the storage backend and reports the result through
+// the gRPC health service and the HTTP probe endpoints
+type Checker struct {
+	// Holds the database.Store once it has been created, the storage is unavailable until then
+	store     atomic.Value
+	stored    chan struct{}
+	Server    *grpcHealth.Server
+	available int32
+}
+
+// NewChecker creates a checker reporting the storage as unavailable until SetStore is called,
+// so the probes can be served while connecting
+func NewChecker() *Checker {
+	checker := &Checker{
+		stored: make(chan struct{}, 1),
+		Server: grpcHealth.NewServer(),
+	}
+	checker.setAvailable(false)
+	return checker
+}
+
+// SetStore starts checking store, a running checker checks it immediately
+func (checker *Checker) SetStore(store database.Store) {
+	checker.store.Store(store)
+	select {
+	case checker.stored <- struct{}{}:
+	default:
+	}
+}
+
+func (checker *Checker) setAvailable(available bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	var value int32 = 0
+	if available {
+		status = healthpb.HealthCheckResponse_SERVING
+		value = 1
+	}
+
+	if atomic.SwapInt32(&checker.available, value) != value {
+		logger.Infow("Storage availability changed", "status", status.String())
+	}
+
+	checker.Server.SetServingStatus("", status)
+	checker.Server.SetServingStatus(storageService, status)
+}
+
+func (checker *Checker) Available() bool {
+	return atomic.LoadInt32(&checker.available) == 1
+}
+
+func (checker *Checker) check(ctx context.Context, timeout time.Duration) {
+	store, ok := checker.store.Load().(database.Store)
+	if !ok {
+		checker.setAvailable(false)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := store.Ping(ctx)
+	if err != nil {
+		logger.Warnw("Storage ping failed", "error", err)
+	}
+	checker.setAvailable(err == nil)
+}
+
+// Run checks the storage in the given interval until the context is cancelled
+func (checker *Checker) Run(ctx context.Context, interval time.Duration) {
+	checker.check(ctx, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checker.check(ctx, interval)
+		case <-checker.stored:
+			checker.check(ctx, interval)
+		}
+	}
+}
+
+// ServeHttp serves /healthz for liveness and /readyz for readiness probes.
+// Liveness does not depend on the storage so an outage does not restart the service.
+func (checker *Checker) ServeHttp(port uint32) error {
+	logger.Infow("Serving health endpoints", "port", port)
+	return http.ListenAndServe(fmt.Sprintf(":%v", port), checker.handler())
+}
+
+func (checker *Checker) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+		_, _ = writer.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(writer http.ResponseWriter, _ *http.Request) {
+		if !checker.Available() {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = writer.Write([]byte("storage unavailable"))
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+		_, _ = writer.Write([]byte("ok"))
+	})
+	return mux
+}
@@ -0,0 +1,43 @@
+package health
+
+import (
+	"context"
+	"github.com/kulycloud/storage-redis/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func probe(t *testing.T, checker *Checker, path string) int {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	checker.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder.Code
+}
+
+func TestReadyzBeforeStore(t *testing.T) {
+	checker := NewChecker()
+	checker.check(context.Background(), time.Second)
+
+	if code := probe(t, checker, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz returned %v before the store exists, want %v", code, http.StatusOK)
+	}
+	if code := probe(t, checker, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz returned %v before the store exists, want %v", code, http.StatusServiceUnavailable)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go checker.Run(ctx, time.Hour)
+	checker.SetStore(database.NewMemoryStore())
+
+	deadline := time.Now().Add(time.Second)
+	for !checker.Available() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if code := probe(t, checker, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz returned %v once the store is set, want %v", code, http.StatusOK)
+	}
+}
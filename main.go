@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"github.com/kulycloud/common/logging"
 	"github.com/kulycloud/storage-redis/communication"
 	"github.com/kulycloud/storage-redis/config"
 	"github.com/kulycloud/storage-redis/database"
+	"github.com/kulycloud/storage-redis/health"
+	"github.com/kulycloud/storage-redis/metrics"
+	"github.com/kulycloud/storage-redis/tracing"
+	"math/rand"
 	"time"
 )
 
@@ -12,6 +17,7 @@ var logger = logging.GetForComponent("init")
 
 func main() {
 	defer logging.Sync()
+	rand.Seed(time.Now().UnixNano())
 
 	err := config.ParseConfig()
 	if err != nil {
@@ -19,17 +25,98 @@ func main() {
 	}
 	logger.Infow("Finished parsing config", "config", config.GlobalConfig)
 
+	shutdownTracing, err := tracing.Setup()
+	if err != nil {
+		logger.Fatalw("Error setting up tracing", "error", err)
+	}
+	defer shutdownTracing()
+
+	// Probes are served while connecting, readiness fails until the storage is available
+	checker := health.NewChecker()
+	go checker.Run(context.Background(), time.Duration(config.GlobalConfig.HealthCheckInterval)*time.Second)
+	go func() {
+		err := checker.ServeHttp(config.GlobalConfig.HealthPort)
+		if err != nil {
+			logger.Errorw("Error serving health endpoints", "error", err)
+		}
+	}()
+
+	var store database.Store
+	switch config.GlobalConfig.StorageBackend {
+	case "redis":
+		store = connectRedis()
+	case "memory":
+		logger.Warn("Using in-memory storage, all data is lost on restart")
+		store = database.NewMemoryStore()
+	default:
+		logger.Fatalw("Unknown storage backend", "backend", config.GlobalConfig.StorageBackend)
+	}
+
+	checker.SetStore(store)
+
+	if config.GlobalConfig.MetricsPort > 0 {
+		startMetrics(store)
+	}
+
+	communication.RegisterToControlPlane(store, checker)
+}
+
+// connectRedis connects to redis retrying with exponential backoff and jitter
+func connectRedis() *database.Connector {
 	dbConnector := database.NewConnector()
-	for {
+	backoff := time.Duration(config.GlobalConfig.ConnectInitialBackoff) * time.Millisecond
+	maxBackoff := time.Duration(config.GlobalConfig.ConnectMaxBackoff) * time.Millisecond
+	deadline := time.Duration(config.GlobalConfig.ConnectDeadline) * time.Second
+	start := time.Now()
+
+	for attempt := uint32(1); ; attempt++ {
 		err := dbConnector.Connect()
 		if err == nil {
 			break
 		}
 
-		logger.Errorw("Could not connect dbConnector", "error", err)
-		logger.Info("Retrying in 5s...")
-		time.Sleep(5*time.Second)
+		logger.Errorw("Could not connect dbConnector", "error", err, "attempt", attempt)
+		if config.GlobalConfig.ConnectMaxAttempts > 0 && attempt >= config.GlobalConfig.ConnectMaxAttempts {
+			logger.Fatalw("Giving up connecting dbConnector", "attempts", attempt)
+		}
+		if deadline > 0 && time.Since(start) >= deadline {
+			logger.Fatalw("Giving up connecting dbConnector", "elapsed", time.Since(start))
+		}
+
+		// Equal jitter: wait at least half of the backoff
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		logger.Infow("Retrying", "delay", delay)
+		time.Sleep(delay)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	if config.GlobalConfig.CacheSize > 0 {
+		go dbConnector.RunCacheInvalidation(context.Background())
+		if config.GlobalConfig.RedisClientTracking {
+			go dbConnector.RunClientTracking(context.Background())
+		}
+	}
+
+	if config.GlobalConfig.RevisionGcInterval > 0 {
+		go dbConnector.RunRevisionGc(context.Background(), time.Duration(config.GlobalConfig.RevisionGcInterval)*time.Second)
 	}
 
-	communication.RegisterToControlPlane(dbConnector)
+	return dbConnector
+}
+
+func startMetrics(store database.Store) {
+	if source, ok := store.(metrics.StatsSource); ok {
+		go metrics.RunDomainStats(context.Background(), source, time.Duration(config.GlobalConfig.MetricsStatsInterval)*time.Second)
+	}
+
+	go func() {
+		err := metrics.Serve(config.GlobalConfig.MetricsPort)
+		if err != nil {
+			logger.Errorw("Error serving metrics", "error", err)
+		}
+	}()
 }
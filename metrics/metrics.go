@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"github.com/kulycloud/common/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"time"
+)
+
+var logger = logging.GetForComponent("metrics")
+
+const namespace = "kuly_storage"
+
+var (
+	rpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_requests_total",
+		Help:      "Number of handled RPCs by method and status code",
+	}, []string{"method", "code"})
+
+	rpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Latency of handled RPCs by method",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"method"})
+
+	domainObjects = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "objects",
+		Help:      "Number of stored objects by kind",
+	}, []string{"kind"})
+
+	reclaimedRevisionKeys = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reclaimed_revision_keys_total",
+		Help:      "Number of keys removed by the route revision retention",
+	})
+)
+
+func ObserveRpc(method string, code codes.Code, duration time.Duration) {
+	rpcRequests.WithLabelValues(method, code.String()).Inc()
+	rpcDuration.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+// ObserveReclaimedRevisionKeys counts keys removed by the route revision retention
+func ObserveReclaimedRevisionKeys(count int64) {
+	reclaimedRevisionKeys.Add(float64(count))
+}
+
+type DomainStats struct {
+	Namespaces int64
+	Routes     int64
+	Services   int64
+	Revisions  int64
+}
+
+// StatsSource is implemented by storage backends able to count their objects
+type StatsSource interface {
+	GetDomainStats(ctx context.Context) (*DomainStats, error)
+}
+
+func updateDomainStats(ctx context.Context, source StatsSource) {
+	stats, err := source.GetDomainStats(ctx)
+	if err != nil {
+		logger.Warnw("Could not collect domain stats", "error", err)
+		return
+	}
+
+	domainObjects.WithLabelValues("namespaces").Set(float64(stats.Namespaces))
+	domainObjects.WithLabelValues("routes").Set(float64(stats.Routes))
+	domainObjects.WithLabelValues("services").Set(float64(stats.Services))
+	domainObjects.WithLabelValues("revisions").Set(float64(stats.Revisions))
+}
+
+// RunDomainStats periodically updates the object gauges until the context is cancelled
+func RunDomainStats(ctx context.Context, source StatsSource, interval time.Duration) {
+	updateDomainStats(ctx, source)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			updateDomainStats(ctx, source)
+		}
+	}
+}
+
+func Serve(port uint32) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	logger.Infow("Serving metrics", "port", port)
+	return http.ListenAndServe(fmt.Sprintf(":%v", port), mux)
+}
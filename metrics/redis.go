@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+var (
+	redisCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Latency of redis commands and pipelines",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 14),
+	}, []string{"command"})
+
+	redisCommandErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_command_errors_total",
+		Help:      "Number of failed redis commands and pipelines",
+	}, []string{"command"})
+)
+
+type startTimeKey struct{}
+
+var _ redis.Hook = redisHook{}
+
+type redisHook struct{}
+
+func observeRedis(ctx context.Context, command string, err error) {
+	if start, ok := ctx.Value(startTimeKey{}).(time.Time); ok {
+		redisCommandDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	}
+	if err != nil && err != redis.Nil {
+		redisCommandErrors.WithLabelValues(command).Inc()
+	}
+}
+
+func (redisHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startTimeKey{}, time.Now()), nil
+}
+
+func (redisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeRedis(ctx, cmd.Name(), cmd.Err())
+	return nil
+}
+
+func (redisHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startTimeKey{}, time.Now()), nil
+}
+
+func (redisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil && cmd.Err() != redis.Nil {
+			err = cmd.Err()
+			break
+		}
+	}
+	observeRedis(ctx, "pipeline", err)
+	return nil
+}
+
+type poolStatsProvider interface {
+	PoolStats() *redis.PoolStats
+}
+
+// InstrumentRedis records the command latencies and pool stats of a client, it must only be called once
+func InstrumentRedis(client redis.UniversalClient) {
+	client.AddHook(redisHook{})
+
+	provider, ok := client.(poolStatsProvider)
+	if !ok {
+		return
+	}
+
+	// Hits, misses and timeouts only ever increase, the connection counts go up and down
+	poolCounter := func(name string, help string, value func(stats *redis.PoolStats) uint32) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "redis_pool_" + name + "_total",
+			Help:      help,
+		}, func() float64 {
+			return float64(value(provider.PoolStats()))
+		})
+	}
+	poolGauge := func(name string, help string, value func(stats *redis.PoolStats) uint32) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "redis_pool_" + name,
+			Help:      help,
+		}, func() float64 {
+			return float64(value(provider.PoolStats()))
+		})
+	}
+
+	poolCounter("hits", "Number of times a free connection was found in the pool", func(stats *redis.PoolStats) uint32 { return stats.Hits })
+	poolCounter("misses", "Number of times no free connection was found in the pool", func(stats *redis.PoolStats) uint32 { return stats.Misses })
+	poolCounter("timeouts", "Number of timeouts waiting for a connection", func(stats *redis.PoolStats) uint32 { return stats.Timeouts })
+	poolGauge("connections", "Number of connections in the pool", func(stats *redis.PoolStats) uint32 { return stats.TotalConns })
+	poolGauge("idle_connections", "Number of idle connections in the pool", func(stats *redis.PoolStats) uint32 { return stats.IdleConns })
+	poolGauge("stale_connections", "Number of stale connections removed from the pool", func(stats *redis.PoolStats) uint32 { return stats.StaleConns })
+}
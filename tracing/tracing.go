@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"github.com/kulycloud/common/logging"
+	"github.com/kulycloud/storage-redis/config"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/propagation"
+	"go.opentelemetry.io/otel/api/trace"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	sdkResource "go.opentelemetry.io/otel/sdk/resource"
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"strings"
+)
+
+var logger = logging.GetForComponent("tracing")
+
+const tracerName = "github.com/kulycloud/storage-redis"
+
+// Setup installs the OTLP exporter if an endpoint is configured. Without endpoint the no-op tracer stays active.
+// The returned function flushes all pending spans.
+func Setup() (func(), error) {
+	global.SetPropagators(propagation.New(
+		propagation.WithExtractors(trace.DefaultHTTPPropagator()),
+		propagation.WithInjectors(trace.DefaultHTTPPropagator()),
+	))
+
+	if config.GlobalConfig.TracingEndpoint == "" {
+		return func() {}, nil
+	}
+
+	options := []otlp.ExporterOption{otlp.WithAddress(config.GlobalConfig.TracingEndpoint)}
+	if config.GlobalConfig.TracingInsecure {
+		options = append(options, otlp.WithInsecure())
+	}
+
+	exporter, err := otlp.NewExporter(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	processor, err := sdkTrace.NewBatchSpanProcessor(exporter)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := sdkTrace.NewProvider(
+		sdkTrace.WithConfig(sdkTrace.Config{
+			DefaultSampler: sdkTrace.ParentSample(sdkTrace.ProbabilitySampler(config.GlobalConfig.TracingSampleRatio)),
+		}),
+		sdkTrace.WithResource(sdkResource.New(semconv.ServiceNameKey.String("storage-redis"))),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider.RegisterSpanProcessor(processor)
+	global.SetTraceProvider(provider)
+
+	logger.Infow("Exporting traces", "endpoint", config.GlobalConfig.TracingEndpoint)
+
+	return func() {
+		processor.Shutdown()
+		err := exporter.Stop()
+		if err != nil {
+			logger.Warnw("Error stopping trace exporter", "error", err)
+		}
+	}, nil
+}
+
+func StartSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return global.Tracer(tracerName).Start(ctx, name)
+}
+
+// metadataSupplier gives the propagators access to gRPC metadata
+type metadataSupplier struct {
+	md metadata.MD
+}
+
+func (supplier *metadataSupplier) Get(key string) string {
+	values := supplier.md.Get(strings.ToLower(key))
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (supplier *metadataSupplier) Set(key string, value string) {
+	supplier.md.Set(strings.ToLower(key), value)
+}
+
+// StartRpcSpan starts the server span of an RPC continuing the trace of the caller
+func StartRpcSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		ctx = propagation.ExtractHTTP(ctx, global.Propagators(), &metadataSupplier{md: md})
+	}
+
+	return global.Tracer(tracerName).Start(ctx, "Storage/"+method, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// EndRpcSpan records the result of the RPC and ends the span
+func EndRpcSpan(span trace.Span, code codes.Code, err error) {
+	if err != nil {
+		span.SetStatus(otelCodes.Code(code), err.Error())
+	}
+	span.End()
+}